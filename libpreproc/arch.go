@@ -0,0 +1,128 @@
+package libpreproc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//Profile - target processor: register and address width and supported
+//instruction forms
+type Profile struct {
+	//Name is used in ARCHITECTURE of linker script
+	Name string
+	//RegisterBits is width of registers A and B
+	RegisterBits int
+	//AddressBits is width of program counter
+	AddressBits int
+	//PortAddressing is set if IN and OUT use the other register as port address
+	PortAddressing bool
+	//Opcodes lists supported 4-bit opcodes
+	Opcodes []byte
+}
+
+var (
+	baseOpcodes = []byte{
+		opAddAIm, opMovAB, opInA, opMovAIm, opMovBA, opAddBIm, opInB, opMovBIm,
+		opOutB, opOutIm, opJncIm, opJmpIm,
+	}
+	extendedOpcodes = append([]byte{opCmpAB, opMovBPC, opJncB, opJmpB}, baseOpcodes...)
+)
+
+var profiles = map[string]*Profile{}
+
+func init() {
+	for _, p := range []*Profile{
+		{Name: "td4", RegisterBits: 4, AddressBits: 4, Opcodes: baseOpcodes},
+		{Name: "td4e", RegisterBits: 4, AddressBits: 4, PortAddressing: true, Opcodes: extendedOpcodes},
+		{Name: "td4e8", RegisterBits: 8, AddressBits: 8, PortAddressing: true, Opcodes: extendedOpcodes},
+		{Name: "td8", RegisterBits: 8, AddressBits: 8, Opcodes: baseOpcodes},
+	} {
+		if err := RegisterProfile(p); err != nil {
+			panic(err)
+		}
+	}
+}
+
+//RegisterProfile adds target processor to the registry
+func RegisterProfile(p *Profile) error {
+	name := strings.ToLower(p.Name)
+	if _, dup := profiles[name]; dup {
+		return fmt.Errorf("architecture %q is already registered", p.Name)
+	}
+	if p.RegisterBits <= 0 || p.AddressBits <= 0 {
+		return fmt.Errorf("architecture %q: register and address width must be positive", p.Name)
+	}
+	profiles[name] = p
+	return nil
+}
+
+//LookupProfile returns target processor by its name
+func LookupProfile(name string) (*Profile, error) {
+	p, ok := profiles[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown architecture %q, known are %s", name, strings.Join(ProfileNames(), ", "))
+	}
+	return p, nil
+}
+
+//ProfileNames returns names of registered processors in alphabetical order
+func ProfileNames() []string {
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *Profile) String() string {
+	return p.Name
+}
+
+//Supports reports whether 4-bit opcode is available on the processor
+func (p *Profile) Supports(opcode byte) bool {
+	for _, op := range p.Opcodes {
+		if op == opcode {
+			return true
+		}
+	}
+	return false
+}
+
+//registerMask returns bit mask of registers
+func (p *Profile) registerMask() int {
+	return 1<<uint(p.RegisterBits) - 1
+}
+
+//addressMask returns bit mask of program counter
+func (p *Profile) addressMask() int {
+	return 1<<uint(p.AddressBits) - 1
+}
+
+//CheckBlock returns error for the first opcode of preprocessed block placed
+//at origin which is not available on the processor or lies beyond its
+//program counter
+func (p *Profile) CheckBlock(origin int, blk Block) error {
+	addr := origin
+	for _, stmt := range blk.elements {
+		op, ok := stmt.(Opcode)
+		if !ok {
+			addr += stmtSize(stmt)
+			continue
+		}
+		if addr > p.addressMask() {
+			return errorAt(posOf(stmt), "%s at address %d is beyond %d-bit program counter of %s", opcodeString(op), addr, p.AddressBits, p.Name)
+		}
+		addr++
+		//operands don't change opcode, so they are not evaluated
+		code, err := Encode(op, func(Ident) (int, error) { return 0, nil })
+		if err != nil {
+			return withPos(posOf(stmt), err)
+		}
+		if !p.Supports(code >> immBits) {
+			return errorAt(posOf(stmt), "%s is not available on %s", opcodeString(op), p.Name)
+		}
+	}
+	return nil
+}
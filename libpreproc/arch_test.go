@@ -0,0 +1,47 @@
+package libpreproc
+
+import "testing"
+
+func TestLookupProfile(t *testing.T) {
+	p, err := LookupProfile("TD4E")
+	if err != nil || p.Name != "td4e" {
+		t.Errorf("got %v %v, want td4e", p, err)
+	}
+	_, err = LookupProfile("z80")
+	if want := `unknown architecture "z80", known are td4, td4e, td4e8, td8`; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+	if err := RegisterProfile(&Profile{Name: "Td4", RegisterBits: 4, AddressBits: 4}); err == nil {
+		t.Error("duplicate profile: error expected")
+	}
+	if err := RegisterProfile(&Profile{Name: "zero", AddressBits: 4}); err == nil {
+		t.Error("profile without registers: error expected")
+	}
+}
+
+func TestCheckBlock(t *testing.T) {
+	tests := []struct {
+		arch   string
+		origin int
+		src    string
+		want   string
+	}{
+		{"td4", 0, "    cmp a, b, 0\n", "2:5: cmp a, b, 0 is not available on td4"},
+		{"td4", 0, "    jmp b\n", "2:5: jmp b is not available on td4"},
+		{"td8", 0, "    mov b, pc, 1\n", "2:5: mov b, pc, 1 is not available on td8"},
+		{"td4e", 0, "    cmp a, b, 0\n    jmp b\n", ""},
+		{"td4", 15, "    out 1\n    out 2\n", "3:5: out 2 at address 16 is beyond 4-bit program counter of td4"},
+		{"td4e8", 15, "    out 1\n    out 2\n", ""},
+	}
+	for _, tt := range tests {
+		arch, err := LookupProfile(tt.arch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prog := processSource(t, "section .text\n"+tt.src)
+		err = arch.CheckBlock(tt.origin, prog.sections[0].sectionContent)
+		if tt.want == "" && err != nil || tt.want != "" && (err == nil || err.Error() != tt.want) {
+			t.Errorf("%s %q: got error %v, want %q", tt.arch, tt.src, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package libpreproc
+
+import "fmt"
+
+//Machine opcodes from the TD4 instruction table
+const (
+	opAddAIm   byte = 0x0 //add a, Im
+	opMovAB    byte = 0x1 //mov a, b
+	opInA      byte = 0x2 //in a
+	opMovAIm   byte = 0x3 //mov a, Im
+	opMovBA    byte = 0x4 //mov b, a
+	opAddBIm   byte = 0x5 //add b, Im
+	opInB      byte = 0x6 //in b
+	opMovBIm   byte = 0x7 //mov b, Im
+	opCmpAB    byte = 0x8 //cmp a, b, Im
+	opOutB     byte = 0x9 //out b
+	opMovBPC   byte = 0xA //mov b, pc
+	opOutIm    byte = 0xB //out Im
+	opJncB     byte = 0xC //jnc b
+	opJmpB     byte = 0xD //jmp b
+	opJncIm    byte = 0xE //jnc Im
+	opJmpIm    byte = 0xF //jmp Im
+	immMask    byte = 0xF
+	immBits         = 4
+	immLowest       = -(1 << (immBits - 1))
+	immHighest      = 1<<immBits - 1
+)
+
+//Immediate - resolves operand into its numeric value
+type Immediate func(id Ident) (int, error)
+
+//NumberValue - immediate resolver accepting numbers and constant
+//expressions of numbers only
+func NumberValue(id Ident) (int, error) {
+	return Eval(id, numberLeaf)
+}
+
+func numberLeaf(id Ident) (int, error) {
+	switch v := id.(type) {
+	case nil:
+		return 0, nil
+	case Number:
+		return v.value, nil
+	case Location:
+		return 0, fmt.Errorf("location $ is unknown before linking")
+	case Variable:
+		return 0, fmt.Errorf("unresolved symbol %q", v.name)
+	case Label:
+		name, _ := definitionName(v)
+		return 0, fmt.Errorf("unresolved label %q", name)
+	}
+	return 0, fmt.Errorf("number expected, met %q", messageText(id))
+}
+
+//Encode returns machine code of one opcode
+func Encode(op Opcode, imm Immediate) (byte, error) {
+	var code byte
+	var operand Ident
+	switch v := op.(type) {
+	case Add:
+		switch v.reg {
+		case a:
+			code = opAddAIm
+		case b:
+			code = opAddBIm
+		default:
+			return 0, fmt.Errorf("invalid combination: add %s, Im", v.reg)
+		}
+		operand = v.value
+	case Mov:
+		switch {
+		case v.reg1 == a && v.reg2 == b:
+			code = opMovAB
+		case v.reg1 == a && v.reg2 == nr:
+			code = opMovAIm
+		case v.reg1 == b && v.reg2 == a:
+			code = opMovBA
+		case v.reg1 == b && v.reg2 == nr:
+			code = opMovBIm
+		case v.reg1 == b && v.reg2 == pc:
+			code = opMovBPC
+		case v.reg2 == nr:
+			return 0, fmt.Errorf("invalid combination: mov %s, Im", v.reg1)
+		default:
+			return 0, fmt.Errorf("invalid combination: mov %s, %s", v.reg1, v.reg2)
+		}
+		operand = v.fa
+	case In:
+		switch v.reg {
+		case a:
+			code = opInA
+		case b:
+			code = opInB
+		default:
+			return 0, fmt.Errorf("invalid combination: in %s", v.reg)
+		}
+		operand = v.fa
+	case Out:
+		switch v.reg {
+		case b:
+			code = opOutB
+		case nr:
+			code = opOutIm
+		default:
+			return 0, fmt.Errorf("invalid combination: out %s", v.reg)
+		}
+		operand = v.fa
+	case Cmp:
+		if v.regA != a || v.regB != b {
+			return 0, fmt.Errorf("invalid combination: cmp %s, %s", v.regA, v.regB)
+		}
+		code = opCmpAB
+		operand = v.operation
+	case Jmp:
+		switch v.regB {
+		case b:
+			code = opJmpB
+		case nr:
+			code = opJmpIm
+		default:
+			return 0, fmt.Errorf("invalid combination: jmp %s", v.regB)
+		}
+		operand = v.addr
+	case Jnc:
+		switch v.regB {
+		case b:
+			code = opJncB
+		case nr:
+			code = opJncIm
+		default:
+			return 0, fmt.Errorf("invalid combination: jnc %s", v.regB)
+		}
+		operand = v.addr
+	default:
+		return 0, fmt.Errorf("opcode expected, met %v", op)
+	}
+	value, err := imm(operand)
+	if err != nil {
+		return 0, err
+	}
+	if value < immLowest || value > immHighest {
+		return 0, fmt.Errorf("immediate %d does not fit into %d bits", value, immBits)
+	}
+	return code<<immBits | byte(value)&immMask, nil
+}
+
+//AssembleBlock encodes opcodes and data of preprocessed block
+func AssembleBlock(blk Block, imm Immediate) ([]byte, error) {
+	var code []byte
+	for _, stmt := range blk.elements {
+		switch v := stmt.(type) {
+		case Label, Comment:
+			//labels and comments take no space
+		case Number:
+			if v.value < 0 || v.value > 0xFF {
+				return code, errorAt(v.pos, "data value %d does not fit into byte", v.value)
+			}
+			code = append(code, byte(v.value))
+		case SimpleString:
+			code = append(code, []byte(v.value)...)
+		case Data:
+			data, err := assembleData(v, imm)
+			if err != nil {
+				return code, withPos(v.pos, err)
+			}
+			code = append(code, data...)
+		case Reserve:
+			size, err := reserveSize(v)
+			if err != nil {
+				return code, withPos(v.pos, err)
+			}
+			code = append(code, make([]byte, size)...)
+		case Opcode:
+			op, err := Encode(v, imm)
+			if err != nil {
+				return code, withPos(posOf(stmt), err)
+			}
+			code = append(code, op)
+		}
+	}
+	return code, nil
+}
+
+//dataRange returns range of values allowed in data definition
+func dataRange(kind Token) (int, int) {
+	if kind == NIBBLE {
+		return immLowest, immHighest
+	}
+	return -0x80, 0xFF
+}
+
+//assembleData encodes data values, one memory cell per value
+func assembleData(data Data, imm Immediate) ([]byte, error) {
+	var code []byte
+	lowest, highest := dataRange(data.kind)
+	for _, value := range data.values {
+		if str, ok := value.(SimpleString); ok {
+			if data.kind == NIBBLE {
+				return code, fmt.Errorf("string in .nibble data")
+			}
+			code = append(code, []byte(str.value)...)
+			continue
+		}
+		n, err := imm(value)
+		if err != nil {
+			return code, err
+		}
+		if n < lowest || n > highest {
+			return code, fmt.Errorf("data value %d is out of range %d..%d", n, lowest, highest)
+		}
+		if data.kind == NIBBLE {
+			code = append(code, byte(n)&immMask)
+		} else {
+			code = append(code, byte(n))
+		}
+	}
+	if data.kind == STRING {
+		code = append(code, 0)
+	}
+	return code, nil
+}
+
+//dataSize returns number of memory cells taken by data definition
+func dataSize(data Data) int {
+	size := 0
+	for _, value := range data.values {
+		if str, ok := value.(SimpleString); ok {
+			size += len(str.value)
+		} else {
+			size++
+		}
+	}
+	if data.kind == STRING {
+		size++
+	}
+	return size
+}
+
+//reserveSize returns number of reserved cells, size has to be known
+//before labels are resolved, so it can't refer to labels
+func reserveSize(res Reserve) (int, error) {
+	size, err := NumberValue(res.size)
+	if err != nil {
+		return 0, err
+	}
+	if size < 0 {
+		return 0, fmt.Errorf("negative .space size %d", size)
+	}
+	return size, nil
+}
+
+//stmtSize returns number of bytes statement takes in machine code
+func stmtSize(stmt Stmt) int {
+	switch v := stmt.(type) {
+	case Label:
+		return 0
+	case Number:
+		return 1
+	case SimpleString:
+		return len(v.value)
+	case Data:
+		return dataSize(v)
+	case Reserve:
+		size, _ := reserveSize(v)
+		return size
+	case Add, Mov, In, Out, Cmp, Jmp, Jnc:
+		return 1
+	}
+	return 0
+}
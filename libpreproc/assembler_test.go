@@ -0,0 +1,96 @@
+package libpreproc
+
+import (
+	"strings"
+	"testing"
+)
+
+//parseOpcode parses one line of source into opcode
+func parseOpcode(t *testing.T, src string) Opcode {
+	t.Helper()
+	stmt, err := NewParser(strings.NewReader(src + "\n")).Parse()
+	if err != nil {
+		t.Fatalf("parse %q: %v", src, err)
+	}
+	op, ok := stmt.(Opcode)
+	if !ok {
+		t.Fatalf("parse %q: opcode expected, met %T", src, stmt)
+	}
+	return op
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		src  string
+		want byte
+	}{
+		{"add a, 2", 0x02},
+		{"mov a, b", 0x10},
+		{"mov a, b, 1", 0x11},
+		{"in a", 0x20},
+		{"in a, 1", 0x21},
+		{"mov a, 5", 0x35},
+		{"mov b, a", 0x40},
+		{"add b, 15", 0x5F},
+		{"in b", 0x60},
+		{"in b, 15", 0x6F},
+		{"mov b, 3", 0x73},
+		{"cmp a, b, 1", 0x81},
+		{"out b", 0x90},
+		{"mov b, pc, 2", 0xA2},
+		{"out 7", 0xB7},
+		{"jnc b", 0xC0},
+		{"jmp b", 0xD0},
+		{"jnc 4", 0xE4},
+		{"jmp 0", 0xF0},
+		{"add a, -8", 0x08},
+		{"add a, -1", 0x0F},
+		{"mov a, 1 + 2 * 3", 0x37},
+	}
+	for _, tt := range tests {
+		got, err := Encode(parseOpcode(t, tt.src), NumberValue)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %#02x, want %#02x", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"add a, 16", "immediate 16 does not fit into 4 bits"},
+		{"add a, -9", "immediate -9 does not fit into 4 bits"},
+		{"mov pc, a", "invalid combination: mov pc, a"},
+		{"add pc, 1", "invalid combination: add pc, Im"},
+		{"jmp a", "invalid combination: jmp a"},
+		{"cmp b, a, 0", "invalid combination: cmp b, a"},
+		{"jmp label", `unresolved symbol "label"`},
+	}
+	for _, tt := range tests {
+		_, err := Encode(parseOpcode(t, tt.src), NumberValue)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%s: got error %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestAssembleBlockData(t *testing.T) {
+	prog, err := NewParser(strings.NewReader("section .data\n    .nibble 1, 0xF\n    .byte 200, \"ab\"\n    .string \"Hi\"\n    .space 2\n")).ParseFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := AssembleBlock(prog.sections[0].sectionContent, NumberValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{1, 0xF, 200, 'a', 'b', 'H', 'i', 0, 0, 0}
+	if string(code) != string(want) {
+		t.Errorf("got %v, want %v", code, want)
+	}
+}
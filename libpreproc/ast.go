@@ -0,0 +1,385 @@
+package libpreproc
+
+//Node - element of syntax tree. The interface is sealed, nodes are
+//created by Parser, Preprocessor and Disassemble only
+type Node interface {
+	Pos() Pos
+	Kind() Kind
+	node()
+}
+
+//Kind - type of node
+type Kind int
+
+//Kinds of nodes
+const (
+	KindInvalid Kind = iota
+	KindProgram
+	KindSection
+	KindBlock
+	KindSimpleString
+	KindNumber
+	KindVariable
+	KindBinary
+	KindUnary
+	KindCall
+	KindLocation
+	KindDefine
+	KindImport
+	KindLine
+	KindPragma
+	KindWarn
+	KindSumdef
+	KindResdef
+	KindPext
+	KindError
+	KindUndef
+	KindIfdef
+	KindIfndef
+	KindIf
+	KindMacro
+	KindFor
+	KindRept
+	KindWhile
+	KindReturn
+	KindMacroCall
+	KindLabel
+	KindData
+	KindReserve
+	KindComment
+	KindAdd
+	KindMov
+	KindIn
+	KindOut
+	KindCmp
+	KindJmp
+	KindJnc
+)
+
+var kindNames = [...]string{
+	KindInvalid:      "invalid",
+	KindProgram:      "program",
+	KindSection:      "section",
+	KindBlock:        "block",
+	KindSimpleString: "string",
+	KindNumber:       "number",
+	KindVariable:     "variable",
+	KindBinary:       "binary",
+	KindUnary:        "unary",
+	KindCall:         "call",
+	KindLocation:     "location",
+	KindDefine:       "define",
+	KindImport:       "import",
+	KindLine:         "line",
+	KindPragma:       "pragma",
+	KindWarn:         "warn",
+	KindSumdef:       "sumdef",
+	KindResdef:       "resdef",
+	KindPext:         "pext",
+	KindError:        "error",
+	KindUndef:        "undef",
+	KindIfdef:        "ifdef",
+	KindIfndef:       "ifndef",
+	KindIf:           "if",
+	KindMacro:        "macro",
+	KindFor:          "for",
+	KindRept:         "rept",
+	KindWhile:        "while",
+	KindReturn:       "return",
+	KindMacroCall:    "macro_call",
+	KindLabel:        "label",
+	KindData:         "data",
+	KindReserve:      "reserve",
+	KindComment:      "comment",
+	KindAdd:          "add",
+	KindMov:          "mov",
+	KindIn:           "in",
+	KindOut:          "out",
+	KindCmp:          "cmp",
+	KindJmp:          "jmp",
+	KindJnc:          "jnc",
+}
+
+func (k Kind) String() string {
+	if k >= 0 && int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return kindNames[KindInvalid]
+}
+
+//ParseKind returns kind by its name
+func ParseKind(name string) (Kind, bool) {
+	for k, kindName := range kindNames {
+		if kindName == name && Kind(k) != KindInvalid {
+			return Kind(k), true
+		}
+	}
+	return KindInvalid, false
+}
+
+func (Program) Kind() Kind      { return KindProgram }
+func (Section) Kind() Kind      { return KindSection }
+func (Block) Kind() Kind        { return KindBlock }
+func (SimpleString) Kind() Kind { return KindSimpleString }
+func (Number) Kind() Kind       { return KindNumber }
+func (Variable) Kind() Kind     { return KindVariable }
+func (Binary) Kind() Kind       { return KindBinary }
+func (Unary) Kind() Kind        { return KindUnary }
+func (Call) Kind() Kind         { return KindCall }
+func (Location) Kind() Kind     { return KindLocation }
+func (Define) Kind() Kind       { return KindDefine }
+func (Import) Kind() Kind       { return KindImport }
+func (Line) Kind() Kind         { return KindLine }
+func (Pragma) Kind() Kind       { return KindPragma }
+func (Warn) Kind() Kind         { return KindWarn }
+func (Sumdef) Kind() Kind       { return KindSumdef }
+func (Resdef) Kind() Kind       { return KindResdef }
+func (Pext) Kind() Kind         { return KindPext }
+func (Error) Kind() Kind        { return KindError }
+func (Undef) Kind() Kind        { return KindUndef }
+func (Ifdef) Kind() Kind        { return KindIfdef }
+func (Ifndef) Kind() Kind       { return KindIfndef }
+func (If) Kind() Kind           { return KindIf }
+func (Macro) Kind() Kind        { return KindMacro }
+func (For) Kind() Kind          { return KindFor }
+func (Rept) Kind() Kind         { return KindRept }
+func (While) Kind() Kind        { return KindWhile }
+func (Return) Kind() Kind       { return KindReturn }
+func (MacroCall) Kind() Kind    { return KindMacroCall }
+func (Label) Kind() Kind        { return KindLabel }
+func (Data) Kind() Kind         { return KindData }
+func (Reserve) Kind() Kind      { return KindReserve }
+func (Comment) Kind() Kind      { return KindComment }
+func (Add) Kind() Kind          { return KindAdd }
+func (Mov) Kind() Kind          { return KindMov }
+func (In) Kind() Kind           { return KindIn }
+func (Out) Kind() Kind          { return KindOut }
+func (Cmp) Kind() Kind          { return KindCmp }
+func (Jmp) Kind() Kind          { return KindJmp }
+func (Jnc) Kind() Kind          { return KindJnc }
+
+func (Program) node()      {}
+func (Section) node()      {}
+func (Block) node()        {}
+func (SimpleString) node() {}
+func (Number) node()       {}
+func (Variable) node()     {}
+func (Binary) node()       {}
+func (Unary) node()        {}
+func (Call) node()         {}
+func (Location) node()     {}
+func (Define) node()       {}
+func (Import) node()       {}
+func (Line) node()         {}
+func (Pragma) node()       {}
+func (Warn) node()         {}
+func (Sumdef) node()       {}
+func (Resdef) node()       {}
+func (Pext) node()         {}
+func (Error) node()        {}
+func (Undef) node()        {}
+func (Ifdef) node()        {}
+func (Ifndef) node()       {}
+func (If) node()           {}
+func (Macro) node()        {}
+func (For) node()          {}
+func (Rept) node()         {}
+func (While) node()        {}
+func (Return) node()       {}
+func (MacroCall) node()    {}
+func (Label) node()        {}
+func (Data) node()         {}
+func (Reserve) node()      {}
+func (Comment) node()      {}
+func (Add) node()          {}
+func (Mov) node()          {}
+func (In) node()           {}
+func (Out) node()          {}
+func (Cmp) node()          {}
+func (Jmp) node()          {}
+func (Jnc) node()          {}
+
+func (SimpleString) identNode() {}
+func (Number) identNode()       {}
+func (Variable) identNode()     {}
+func (Binary) identNode()       {}
+func (Unary) identNode()        {}
+func (Call) identNode()         {}
+func (Location) identNode()     {}
+func (Label) identNode()        {}
+func (MacroCall) identNode()    {}
+
+func (Define) stmtNode()       {}
+func (Import) stmtNode()       {}
+func (Line) stmtNode()         {}
+func (Pragma) stmtNode()       {}
+func (Warn) stmtNode()         {}
+func (Sumdef) stmtNode()       {}
+func (Resdef) stmtNode()       {}
+func (Pext) stmtNode()         {}
+func (Error) stmtNode()        {}
+func (Undef) stmtNode()        {}
+func (Ifdef) stmtNode()        {}
+func (If) stmtNode()           {}
+func (Ifndef) stmtNode()       {}
+func (Macro) stmtNode()        {}
+func (For) stmtNode()          {}
+func (Rept) stmtNode()         {}
+func (While) stmtNode()        {}
+func (Return) stmtNode()       {}
+func (MacroCall) stmtNode()    {}
+func (Label) stmtNode()        {}
+func (Data) stmtNode()         {}
+func (Reserve) stmtNode()      {}
+func (Comment) stmtNode()      {}
+func (Number) stmtNode()       {}
+func (SimpleString) stmtNode() {}
+func (Add) stmtNode()          {}
+func (Mov) stmtNode()          {}
+func (In) stmtNode()           {}
+func (Out) stmtNode()          {}
+func (Cmp) stmtNode()          {}
+func (Jmp) stmtNode()          {}
+func (Jnc) stmtNode()          {}
+
+func (Add) opcodeNode() {}
+func (Mov) opcodeNode() {}
+func (In) opcodeNode()  {}
+func (Out) opcodeNode() {}
+func (Cmp) opcodeNode() {}
+func (Jmp) opcodeNode() {}
+func (Jnc) opcodeNode() {}
+
+//Visitor - Visit is called by Walk for every node, children of the node
+//are walked with returned visitor unless it is nil. Visit(nil) is called
+//after children
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+//Walk traverses tree in depth-first order
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+	switch n := node.(type) {
+	case Program:
+		for _, section := range n.sections {
+			Walk(v, section)
+		}
+	case Section:
+		Walk(v, n.sectionContent)
+	case Block:
+		for _, stmt := range n.elements {
+			Walk(v, stmt)
+		}
+	case Binary:
+		walkIdents(v, n.x, n.y)
+	case Unary:
+		walkIdents(v, n.x)
+	case Call:
+		walkIdents(v, n.args...)
+	case Define:
+		walkIdents(v, n.name, n.definition)
+	case Import:
+		walkIdents(v, n.name)
+	case Line:
+		walkIdents(v, n.lineNumber, n.name)
+	case Warn:
+		walkIdents(v, n.message)
+	case Error:
+		walkIdents(v, n.message)
+	case Sumdef:
+		walkIdents(v, n.def1, n.def2)
+	case Resdef:
+		walkIdents(v, n.def1, n.def2)
+	case Pext:
+		walkIdents(v, n.pextName, n.pextAddress)
+	case Undef:
+		walkIdents(v, n.definition)
+	case Ifdef:
+		walkIdents(v, n.definition)
+		Walk(v, n.bodyTrue)
+		Walk(v, n.bodyFalse)
+	case Ifndef:
+		walkIdents(v, n.definition)
+		Walk(v, n.bodyTrue)
+		Walk(v, n.bodyFalse)
+	case If:
+		walkIdents(v, n.condition)
+		Walk(v, n.bodyTrue)
+		Walk(v, n.bodyFalse)
+	case Macro:
+		walkIdents(v, n.defaults...)
+		Walk(v, n.body)
+	case For:
+		walkIdents(v, n.variable)
+		walkIdents(v, n.values...)
+		Walk(v, n.body)
+	case Rept:
+		walkIdents(v, n.count, n.variable)
+		Walk(v, n.body)
+	case While:
+		walkIdents(v, n.condition)
+		Walk(v, n.body)
+	case Return:
+		walkIdents(v, n.returnValue)
+	case MacroCall:
+		walkIdents(v, n.args...)
+	case Label:
+		walkIdents(v, n.name)
+	case Data:
+		walkIdents(v, n.values...)
+	case Reserve:
+		walkIdents(v, n.size)
+	case Add:
+		walkIdents(v, n.value)
+	case Mov:
+		walkIdents(v, n.fa)
+	case In:
+		walkIdents(v, n.fa)
+	case Out:
+		walkIdents(v, n.fa)
+	case Cmp:
+		walkIdents(v, n.operation)
+	case Jmp:
+		walkIdents(v, n.addr)
+	case Jnc:
+		walkIdents(v, n.addr)
+	}
+	v.Visit(nil)
+}
+
+//walkIdents walks operands, omitted ones are nil and skipped
+func walkIdents(v Visitor, ids ...Ident) {
+	for _, id := range ids {
+		if id != nil {
+			Walk(v, id)
+		}
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+//Inspect traverses tree in depth-first order calling f for every node
+//and f(nil) after its children. Children are skipped if f returns false
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+//Token is returned by Parser.Parse as statement marking the end of block,
+//e.g. EOF or ENDIF, it is never stored in tree
+func (Token) Pos() Pos { return Pos{} }
+
+//Kind of block end marker is KindInvalid
+func (Token) Kind() Kind { return KindInvalid }
+
+func (Token) node()     {}
+func (Token) stmtNode() {}
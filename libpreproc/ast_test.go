@@ -0,0 +1,60 @@
+package libpreproc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	prog, err := NewParser(strings.NewReader("section .text\n#ifdef X\n    add a, 1 + 2\n#endif\nl:  jmp l\n")).ParseFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []string
+	depth, maxDepth := 0, 0
+	Inspect(prog, func(n Node) bool {
+		if n == nil {
+			depth--
+			return false
+		}
+		depth++
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+		kinds = append(kinds, n.Kind().String())
+		return true
+	})
+	want := "program section block ifdef variable block add binary number number block label variable jmp label variable"
+	if got := strings.Join(kinds, " "); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if depth != 0 || maxDepth != 8 {
+		t.Errorf("got depth %d, max %d, want 0, 8", depth, maxDepth)
+	}
+	//children of skipped nodes are not visited
+	kinds = nil
+	Inspect(prog, func(n Node) bool {
+		if n != nil {
+			kinds = append(kinds, n.Kind().String())
+		}
+		return n != nil && n.Kind() != KindIfdef && n.Kind() != KindLabel
+	})
+	want = "program section block ifdef label jmp label"
+	if got := strings.Join(kinds, " "); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestParseKind(t *testing.T) {
+	for k := KindProgram; k <= KindJnc; k++ {
+		if got, ok := ParseKind(k.String()); !ok || got != k {
+			t.Errorf("%s: got %v %t", k, got, ok)
+		}
+	}
+	if _, ok := ParseKind("invalid"); ok {
+		t.Error("invalid kind is parsed")
+	}
+	if got := Kind(-1).String(); got != "invalid" {
+		t.Errorf("got %s, want invalid", got)
+	}
+}
@@ -0,0 +1,110 @@
+package libpreproc
+
+import "fmt"
+
+//Decode returns opcode encoded by machine code byte
+func Decode(code byte) Opcode {
+	im := Number{value: int(code & immMask)}
+	switch code >> immBits {
+	case opAddAIm:
+		return Add{reg: a, value: im}
+	case opMovAB:
+		return Mov{reg1: a, reg2: b, fa: fastAdd(im)}
+	case opInA:
+		return In{reg: a, fa: fastAdd(im)}
+	case opMovAIm:
+		return Mov{reg1: a, reg2: nr, fa: im}
+	case opMovBA:
+		return Mov{reg1: b, reg2: a, fa: fastAdd(im)}
+	case opAddBIm:
+		return Add{reg: b, value: im}
+	case opInB:
+		return In{reg: b, fa: fastAdd(im)}
+	case opMovBIm:
+		return Mov{reg1: b, reg2: nr, fa: im}
+	case opCmpAB:
+		return Cmp{regA: a, regB: b, operation: im}
+	case opOutB:
+		return Out{reg: b, fa: fastAdd(im)}
+	case opMovBPC:
+		return Mov{reg1: b, reg2: pc, fa: fastAdd(im)}
+	case opOutIm:
+		return Out{reg: nr, fa: im}
+	case opJncB:
+		return Jnc{regB: b}
+	case opJmpB:
+		return Jmp{regB: b}
+	case opJncIm:
+		return Jnc{regB: nr, addr: im}
+	}
+	return Jmp{regB: nr, addr: im}
+}
+
+//fastAdd omits zero FastAdd immediate
+func fastAdd(im Number) Ident {
+	if im.value == 0 {
+		return nil
+	}
+	return im
+}
+
+//Disassemble decodes machine code placed at origin into program with one
+//.text section. Absolute jump targets inside the code get generated labels.
+//Opcodes arch does not support are decoded as .byte data, arch may be nil
+func Disassemble(origin int, code []byte, arch *Profile) Program {
+	targets := make(map[int]bool)
+	stmts := make([]Stmt, len(code))
+	for i, c := range code {
+		if arch != nil && !arch.Supports(c>>immBits) {
+			stmts[i] = Data{kind: BYTE, values: []Ident{Number{value: int(c)}}}
+			continue
+		}
+		stmts[i] = Decode(c)
+		if target, ok := jumpTarget(stmts[i]); ok && target >= origin && target < origin+len(code) {
+			targets[target] = true
+		}
+	}
+	var blk Block
+	if origin != 0 {
+		blk.elements = append(blk.elements, Comment{text: fmt.Sprintf("; origin %d", origin)})
+	}
+	for i, stmt := range stmts {
+		if targets[origin+i] {
+			blk.elements = append(blk.elements, Label{name: Variable{name: targetLabel(origin + i)}})
+		}
+		switch v := stmt.(type) {
+		case Jmp:
+			if target, ok := jumpTarget(v); ok && targets[target] {
+				v.addr = Label{name: Variable{name: targetLabel(target)}}
+			}
+			stmt = v
+		case Jnc:
+			if target, ok := jumpTarget(v); ok && targets[target] {
+				v.addr = Label{name: Variable{name: targetLabel(target)}}
+			}
+			stmt = v
+		}
+		blk.elements = append(blk.elements, stmt)
+	}
+	return Program{sections: []Section{{sectionName: ".text", sectionContent: blk}}}
+}
+
+//jumpTarget returns immediate address of jmp and jnc
+func jumpTarget(stmt Stmt) (int, bool) {
+	var addr Ident
+	switch v := stmt.(type) {
+	case Jmp:
+		addr = v.addr
+	case Jnc:
+		addr = v.addr
+	}
+	if num, ok := addr.(Number); ok {
+		return num.value, true
+	}
+	return 0, false
+}
+
+//targetLabel returns name of generated label at address
+func targetLabel(addr int) string {
+	return fmt.Sprintf("L%02X", addr)
+}
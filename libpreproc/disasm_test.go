@@ -0,0 +1,64 @@
+package libpreproc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDecodeRoundTrip(t *testing.T) {
+	for c := 0; c < 256; c++ {
+		//jnc b and jmp b don't use immediate
+		if code := byte(c) >> immBits; (code == opJncB || code == opJmpB) && c&int(immMask) != 0 {
+			continue
+		}
+		op := Decode(byte(c))
+		got, err := Encode(op, NumberValue)
+		if err != nil {
+			t.Errorf("%#02x: %s: %v", c, opcodeString(op), err)
+			continue
+		}
+		if got != byte(c) {
+			t.Errorf("%#02x: %s encodes to %#02x", c, opcodeString(op), got)
+		}
+	}
+	if got := opcodeString(Decode(0x21)); got != "in a, 1" {
+		t.Errorf("0x21: got %s, want in a, 1", got)
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	td4, _ := LookupProfile("td4")
+	//jnc to the loop, cmp is not available on td4, jmp outside of code
+	code := []byte{0x31, 0x01, 0xE3, 0x80, 0xFF}
+	prog := Disassemble(2, code, td4)
+	want := "section .text\n; origin 2\nmov a, 1\nL03:\nadd a, 1\njnc L03\n.byte 128\njmp 15"
+	if got := sourceLines(t, prog); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	//without profile every byte is an opcode
+	want = "section .text\nmov a, 1\nadd a, 1\njnc L03\nL03:\ncmp a, b, 0\njmp 15"
+	if got := sourceLines(t, Disassemble(0, code, nil)); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDisassembleReassemble(t *testing.T) {
+	code := []byte{0x31, 0x01, 0xE1, 0x21, 0x6F, 0xA2, 0xD0, 0xF0}
+	var buf bytes.Buffer
+	if err := WriteSource(&buf, Disassemble(0, code, nil)); err != nil {
+		t.Fatal(err)
+	}
+	src := buf.String()
+	out, table, err := resolve(t, src)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, src)
+	}
+	got, err := AssembleBlock(out.sections[0].sectionContent, table.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, code) {
+		t.Errorf("got % x, want % x\n%s", got, code, strings.TrimSpace(src))
+	}
+}
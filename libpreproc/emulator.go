@@ -0,0 +1,197 @@
+package libpreproc
+
+import "fmt"
+
+//portCount - number of addressable IN/OUT ports on TD4E
+const portCount = 16
+
+//Emulator - instruction level TD4 emulator
+type Emulator struct {
+	arch   *Profile
+	rom    []byte
+	regA   int
+	regB   int
+	pc     int
+	carry  bool
+	in     [portCount]int
+	out    [portCount]int
+	cycles int
+	halted bool
+}
+
+//NewEmulator returns emulator running rom on specified processor
+func NewEmulator(arch *Profile, rom []byte) *Emulator {
+	return &Emulator{arch: arch, rom: rom}
+}
+
+//Reset clears registers and output ports, inputs are kept
+func (e *Emulator) Reset() {
+	e.regA, e.regB, e.pc, e.carry = 0, 0, 0, false
+	e.out = [portCount]int{}
+	e.cycles = 0
+	e.halted = false
+}
+
+//A returns value of register A
+func (e *Emulator) A() int {
+	return e.regA
+}
+
+//B returns value of register B
+func (e *Emulator) B() int {
+	return e.regB
+}
+
+//PC returns program counter
+func (e *Emulator) PC() int {
+	return e.pc
+}
+
+//Carry returns carry flag
+func (e *Emulator) Carry() bool {
+	return e.carry
+}
+
+//Cycles returns number of executed instructions
+func (e *Emulator) Cycles() int {
+	return e.cycles
+}
+
+//Halted reports whether program stopped in a jump to itself
+func (e *Emulator) Halted() bool {
+	return e.halted
+}
+
+//SetA sets value of register A
+func (e *Emulator) SetA(value int) {
+	e.regA = value & e.arch.registerMask()
+}
+
+//SetB sets value of register B
+func (e *Emulator) SetB(value int) {
+	e.regB = value & e.arch.registerMask()
+}
+
+//SetInput sets value of IN port. TD4 has the only port 0
+func (e *Emulator) SetInput(port int, value int) error {
+	if err := checkPort(port); err != nil {
+		return err
+	}
+	e.in[port] = value & e.arch.registerMask()
+	return nil
+}
+
+//Output returns value of OUT port. TD4 has the only port 0
+func (e *Emulator) Output(port int) (int, error) {
+	if err := checkPort(port); err != nil {
+		return 0, err
+	}
+	return e.out[port], nil
+}
+
+func checkPort(port int) error {
+	if port < 0 || port >= portCount {
+		return fmt.Errorf("port %d out of range 0..%d", port, portCount-1)
+	}
+	return nil
+}
+
+//port returns port addressed by register, TD4 has no port addressing
+func (e *Emulator) port(reg int) int {
+	if e.arch.PortAddressing {
+		return reg % portCount
+	}
+	return 0
+}
+
+//fetch returns instruction at address, missing ROM cells read as zero
+func (e *Emulator) fetch(addr int) byte {
+	if addr < len(e.rom) {
+		return e.rom[addr]
+	}
+	return 0
+}
+
+//add returns sum truncated to register width and carry
+func (e *Emulator) add(x int, y int) (int, bool) {
+	sum := x + y
+	mask := e.arch.registerMask()
+	return sum & mask, sum > mask
+}
+
+//Step executes one instruction
+func (e *Emulator) Step() error {
+	instr := e.fetch(e.pc)
+	code, im := instr>>immBits, int(instr&immMask)
+	next := (e.pc + 1) & e.arch.addressMask()
+	if !e.arch.Supports(code) {
+		return fmt.Errorf("opcode %04b at %d is not available on %s", code, e.pc, e.arch)
+	}
+	switch code {
+	case opAddAIm:
+		e.regA, e.carry = e.add(e.regA, im)
+	case opMovAB:
+		e.regA, e.carry = e.add(e.regB, im)
+	case opInA:
+		e.regA, e.carry = e.add(e.in[e.port(e.regB)], im)
+	case opMovAIm:
+		e.regA, e.carry = e.add(0, im)
+	case opMovBA:
+		e.regB, e.carry = e.add(e.regA, im)
+	case opAddBIm:
+		e.regB, e.carry = e.add(e.regB, im)
+	case opInB:
+		e.regB, e.carry = e.add(e.in[e.port(e.regA)], im)
+	case opMovBIm:
+		e.regB, e.carry = e.add(0, im)
+	case opCmpAB:
+		switch im {
+		case 0:
+			e.carry = e.regA == e.regB
+		case 1:
+			e.carry = e.regA > e.regB
+		case 2:
+			e.carry = e.regA < e.regB
+		default:
+			e.carry = false
+		}
+	case opOutB:
+		e.out[e.port(e.regA)], e.carry = e.add(e.regB, im)
+	case opMovBPC:
+		e.regB, e.carry = e.add(e.pc, im)
+	case opOutIm:
+		e.out[e.port(e.regA)], e.carry = im, false
+	case opJncB:
+		if !e.carry {
+			next = e.regB & e.arch.addressMask()
+		}
+		e.carry = false
+	case opJmpB:
+		next, e.carry = e.regB&e.arch.addressMask(), false
+	case opJncIm:
+		if !e.carry {
+			next = im
+		}
+		e.carry = false
+	case opJmpIm:
+		next, e.carry = im, false
+	}
+	e.halted = next == e.pc
+	e.pc = next
+	e.cycles++
+	return nil
+}
+
+//Run executes instructions until program halts or maxCycles are executed
+//and returns number of executed instructions
+func (e *Emulator) Run(maxCycles int) (int, error) {
+	for i := 0; i < maxCycles; i++ {
+		if err := e.Step(); err != nil {
+			return i, err
+		}
+		if e.halted {
+			return i + 1, nil
+		}
+	}
+	return maxCycles, nil
+}
@@ -0,0 +1,91 @@
+package libpreproc
+
+import "testing"
+
+func TestEmulatorStep(t *testing.T) {
+	td4, _ := LookupProfile("td4")
+	td4e8, _ := LookupProfile("td4e8")
+	tests := []struct {
+		name  string
+		arch  *Profile
+		rom   []byte
+		steps int
+		a, b  int
+		carry bool
+		pc    int
+	}{
+		{"add", td4, []byte{0x02, 0x53}, 2, 2, 3, false, 2},
+		{"add sets carry", td4, []byte{0x3F, 0x01}, 2, 0, 0, true, 2},
+		{"mov clears carry", td4, []byte{0x3F, 0x01, 0x35}, 3, 5, 0, false, 3},
+		{"8-bit register", td4e8, []byte{0x3F, 0x01}, 2, 16, 0, false, 2},
+		{"jnc taken without carry", td4, []byte{0xE5}, 1, 0, 0, false, 5},
+		{"jnc not taken with carry", td4, []byte{0x3F, 0x01, 0xE5}, 3, 0, 0, false, 3},
+		{"jmp", td4, []byte{0xF7}, 1, 0, 0, false, 7},
+		{"jmp b", td4e8, []byte{0x79, 0xD0}, 2, 0, 9, false, 9},
+		{"cmp equal", td4e8, []byte{0x33, 0x73, 0x80}, 3, 3, 3, true, 3},
+		{"cmp greater", td4e8, []byte{0x33, 0x73, 0x81}, 3, 3, 3, false, 3},
+		{"pc wraps", td4, make([]byte, 16), 16, 0, 0, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			emu := NewEmulator(tt.arch, tt.rom)
+			for i := 0; i < tt.steps; i++ {
+				if err := emu.Step(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if emu.A() != tt.a || emu.B() != tt.b || emu.Carry() != tt.carry || emu.PC() != tt.pc {
+				t.Errorf("got a=%d b=%d carry=%t pc=%d, want a=%d b=%d carry=%t pc=%d",
+					emu.A(), emu.B(), emu.Carry(), emu.PC(), tt.a, tt.b, tt.carry, tt.pc)
+			}
+		})
+	}
+}
+
+func TestEmulatorUnsupportedOpcode(t *testing.T) {
+	td4, _ := LookupProfile("td4")
+	if err := NewEmulator(td4, []byte{0x80}).Step(); err == nil {
+		t.Error("cmp on td4: error expected")
+	}
+}
+
+func TestEmulatorPorts(t *testing.T) {
+	td4, _ := LookupProfile("td4")
+	td4e, _ := LookupProfile("td4e")
+	tests := []struct {
+		name string
+		arch *Profile
+		//rom reads input port 3 into B and outputs it
+		rom  []byte
+		port int
+	}{
+		{"td4 has one port", td4, []byte{0x33, 0x60, 0x90, 0xF3}, 0},
+		{"td4e addresses port by A", td4e, []byte{0x33, 0x60, 0x90, 0xF3}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			emu := NewEmulator(tt.arch, tt.rom)
+			if err := emu.SetInput(tt.port, 9); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := emu.Run(16); err != nil {
+				t.Fatal(err)
+			}
+			if !emu.Halted() {
+				t.Error("program did not halt")
+			}
+			if out, _ := emu.Output(tt.port); out != 9 {
+				t.Errorf("output %d: got %d, want 9", tt.port, out)
+			}
+		})
+	}
+	emu := NewEmulator(td4e, nil)
+	for _, port := range []int{-1, portCount} {
+		if err := emu.SetInput(port, 1); err == nil {
+			t.Errorf("SetInput(%d): error expected", port)
+		}
+		if _, err := emu.Output(port); err == nil {
+			t.Errorf("Output(%d): error expected", port)
+		}
+	}
+}
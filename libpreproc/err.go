@@ -1,6 +1,10 @@
 package libpreproc
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
 //ErrElseBranch - message that end of branch was met
 var ErrElseBranch = errors.New("ElseBranch")
@@ -10,3 +14,115 @@ var ErrEndIf = errors.New("EndIf")
 
 //ErrMacroEnd - message that end of Macro was reached
 var ErrMacroEnd = errors.New("MacroEnd")
+
+//PosError - error bound to position in source
+type PosError struct {
+	Pos Pos
+	Err error
+}
+
+func (e *PosError) Error() string {
+	if !e.Pos.IsValid() && e.Pos.File == "" {
+		return e.Err.Error()
+	}
+	return e.Pos.String() + ": " + e.Err.Error()
+}
+
+//MacroFrame - macro call being expanded
+type MacroFrame struct {
+	Name string
+	Pos  Pos
+}
+
+//MacroError - error met while expanding macro, Backtrace lists calls
+//from the innermost one
+type MacroError struct {
+	Err       error
+	Backtrace []MacroFrame
+}
+
+//maxBacktrace limits number of calls shown at each end of backtrace
+const maxBacktrace = 5
+
+func (e *MacroError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Err.Error())
+	for i, frame := range e.Backtrace {
+		if skipped := len(e.Backtrace) - 2*maxBacktrace; skipped > 0 && i >= maxBacktrace && i < len(e.Backtrace)-maxBacktrace {
+			if i == maxBacktrace {
+				fmt.Fprintf(&b, "\n\t... %d more calls", skipped)
+			}
+			continue
+		}
+		fmt.Fprintf(&b, "\n\tin macro %s called at %s", frame.Name, frame.Pos)
+	}
+	return b.String()
+}
+
+func (e *MacroError) Unwrap() error {
+	return e.Err
+}
+
+//inMacro adds call to backtrace of error met while expanding macro
+func inMacro(err error, frame MacroFrame) error {
+	if e, ok := err.(*MacroError); ok {
+		e.Backtrace = append(e.Backtrace, frame)
+		return e
+	}
+	return &MacroError{Err: err, Backtrace: []MacroFrame{frame}}
+}
+
+//errorAt returns formatted error bound to position
+func errorAt(pos Pos, format string, args ...interface{}) error {
+	return &PosError{Pos: pos, Err: fmt.Errorf(format, args...)}
+}
+
+//withPos binds error to position unless it already has one
+func withPos(pos Pos, err error) error {
+	if err == nil {
+		return nil
+	}
+	switch err.(type) {
+	case *PosError, *MacroError, ErrorList:
+		return err
+	}
+	return &PosError{Pos: pos, Err: err}
+}
+
+//posOf returns position of statement or identifier
+func posOf(node interface{}) Pos {
+	if n, ok := node.(interface{ Pos() Pos }); ok {
+		return n.Pos()
+	}
+	return Pos{}
+}
+
+//ErrorList - list of errors found in source
+type ErrorList []error
+
+//Add appends error to the list, nested lists are flattened
+func (l *ErrorList) Add(err error) {
+	switch e := err.(type) {
+	case nil:
+	case ErrorList:
+		*l = append(*l, e...)
+	default:
+		*l = append(*l, err)
+	}
+}
+
+//Err returns the list as error or nil if the list is empty
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+func (l ErrorList) Error() string {
+	msgs := make([]string, len(l))
+	for i, err := range l {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
@@ -0,0 +1,161 @@
+package libpreproc
+
+import "fmt"
+
+//function - built-in function of constant expressions
+type function struct {
+	arity int
+	eval  func(args []int) int
+}
+
+//definedFunc - defined(NAME) operator, it is evaluated by preprocessor
+const definedFunc = "defined"
+
+var functions = map[string]function{
+	"hi": {1, func(args []int) int { return args[0] >> immBits & int(immMask) }},
+	"lo": {1, func(args []int) int { return args[0] & int(immMask) }},
+}
+
+//Eval evaluates constant expression. Operands which are not expressions,
+//e.g. variables, labels and location, are resolved by leaf
+func Eval(id Ident, leaf Immediate) (int, error) {
+	switch v := id.(type) {
+	case Number:
+		return v.value, nil
+	case Unary:
+		x, err := Eval(v.x, leaf)
+		if err != nil {
+			return 0, err
+		}
+		return evalUnary(v.op, x), nil
+	case Binary:
+		if equal, ok := stringsEqual(v); ok {
+			return boolValue(equal == (v.op == EQL)), nil
+		}
+		x, err := Eval(v.x, leaf)
+		if err != nil {
+			return 0, err
+		}
+		y, err := Eval(v.y, leaf)
+		if err != nil {
+			return 0, err
+		}
+		value, err := evalBinary(v.op, x, y)
+		if err != nil {
+			return 0, withPos(v.pos, err)
+		}
+		return value, nil
+	case Call:
+		if v.function == definedFunc {
+			return 0, errorAt(v.pos, "defined() is evaluated by preprocessor only")
+		}
+		fn, ok := functions[v.function]
+		if !ok {
+			return 0, errorAt(v.pos, "unknown function %q", v.function)
+		}
+		if len(v.args) != fn.arity {
+			return 0, errorAt(v.pos, "%s expects %d arguments, got %d", v.function, fn.arity, len(v.args))
+		}
+		args := make([]int, len(v.args))
+		for i, arg := range v.args {
+			value, err := Eval(arg, leaf)
+			if err != nil {
+				return 0, err
+			}
+			args[i] = value
+		}
+		return fn.eval(args), nil
+	}
+	return leaf(id)
+}
+
+func evalUnary(op Token, x int) int {
+	switch op {
+	case MINUS:
+		return -x
+	case TILDE:
+		return ^x
+	case NOT:
+		return boolValue(x == 0)
+	}
+	return x
+}
+
+func evalBinary(op Token, x int, y int) (int, error) {
+	switch op {
+	case PLUS:
+		return x + y, nil
+	case MINUS:
+		return x - y, nil
+	case STAR:
+		return x * y, nil
+	case SLASH, PERCENT:
+		if y == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		if op == SLASH {
+			return x / y, nil
+		}
+		return x % y, nil
+	case AMP:
+		return x & y, nil
+	case PIPE:
+		return x | y, nil
+	case CARET:
+		return x ^ y, nil
+	case SHL, SHR:
+		if y < 0 {
+			return 0, fmt.Errorf("negative shift count %d", y)
+		}
+		if op == SHL {
+			return x << uint(y), nil
+		}
+		return x >> uint(y), nil
+	case LAND:
+		return boolValue(x != 0 && y != 0), nil
+	case LOR:
+		return boolValue(x != 0 || y != 0), nil
+	case EQL:
+		return boolValue(x == y), nil
+	case NEQ:
+		return boolValue(x != y), nil
+	case LSS:
+		return boolValue(x < y), nil
+	case LEQ:
+		return boolValue(x <= y), nil
+	case GTR:
+		return boolValue(x > y), nil
+	case GEQ:
+		return boolValue(x >= y), nil
+	}
+	return 0, fmt.Errorf("unknown operator %s", op)
+}
+
+//stringsEqual compares strings of == and != expression,
+//ok is false if operands are not strings
+func stringsEqual(bin Binary) (equal bool, ok bool) {
+	x, xok := bin.x.(SimpleString)
+	y, yok := bin.y.(SimpleString)
+	if !xok || !yok || (bin.op != EQL && bin.op != NEQ) {
+		return false, false
+	}
+	return x.value == y.value, true
+}
+
+func boolValue(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+//fold replaces expression by its value if it consists of numbers only
+func fold(id Ident) Ident {
+	switch id.(type) {
+	case Binary, Unary, Call:
+		if value, err := NumberValue(id); err == nil {
+			return Number{value: value, pos: posOf(id)}
+		}
+	}
+	return id
+}
@@ -0,0 +1,85 @@
+package libpreproc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		src  string
+		want int
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"7 - 2 - 1", 4},
+		{"-3 + 5", 2},
+		{"~0", -1},
+		{"!0 + !5", 1},
+		{"7 / 2 + 7 % 3", 4},
+		{"1 << 4 | 1", 17},
+		{"0x10 >> 2", 4},
+		{"1 + 1 << 2", 8},
+		{"6 & 3 ^ 1", 3},
+		{"5 | 2 ^ 3", 5},
+		{"2 < 3 == 1", 1},
+		{"3 >= 4 || 2 <= 2 && 1 != 0", 1},
+		{"0b101 + 0x10", 21},
+		{"hi(0xA5) + lo(0xA5)", 15},
+		{"\"ab\" == \"ab\"", 1},
+		{"\"ab\" != \"ab\"", 0},
+	}
+	for _, tt := range tests {
+		id, err := NewParser(strings.NewReader(tt.src)).ParseIdent()
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		got, err := Eval(id, NumberValue)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"1 / 0", "1:1: division by zero"},
+		{"1 % (2 - 2)", "1:1: division by zero"},
+		{"1 << -1", "1:1: negative shift count -1"},
+		{"sqrt(4)", `1:1: unknown function "sqrt"`},
+		{"hi(1, 2)", "1:1: hi expects 1 arguments, got 2"},
+		{"defined(X)", "1:1: defined() is evaluated by preprocessor only"},
+	}
+	for _, tt := range tests {
+		//unknown functions are reported by parser
+		id, err := NewParser(strings.NewReader(tt.src)).ParseIdent()
+		if err == nil {
+			_, err = Eval(id, NumberValue)
+		}
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%s: got error %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestEvalLocation(t *testing.T) {
+	out, table, err := resolve(t, "section .text\n    out 1\n    jmp $\nnext:\n    jnc $ + 1\n    mov a, next - $\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := AssembleBlock(out.sections[0].sectionContent, table.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0xB1, 0xF1, 0xE3, 0x3F}; string(code) != string(want) {
+		t.Errorf("got % x, want % x", code, want)
+	}
+}
@@ -0,0 +1,268 @@
+package libpreproc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+//Layout of formatted source
+const (
+	indentWidth   = 4
+	mnemonicWidth = 8
+	commentColumn = 32
+)
+
+//Format writes program as canonical assembler source: labels start at
+//the first column, statements are indented by block depth, operands and
+//trailing comments are aligned. Both parsed and preprocessed programs
+//can be formatted, output parses back to the same program
+func Format(w io.Writer, prog Program) error {
+	f := formatter{w: bufio.NewWriter(w)}
+	//settings made by pragmas of preprocessed program are written back
+	if prog.arch != "" {
+		f.stmtLine(Pos{}, 0, "#pragma", "arch "+prog.arch)
+	}
+	for _, section := range mergeUnnamed(prog.sections) {
+		//statements before the first section are not indented
+		depth := 0
+		if section.sectionName != "" {
+			if f.written {
+				f.blankLine()
+			}
+			f.startLine(section.pos, "section "+section.sectionName)
+			depth = 1
+		}
+		if section.align != 0 {
+			f.stmtLine(Pos{}, depth, "#pragma", fmt.Sprintf("section-align %d", section.align))
+		}
+		if err := f.block(section.sectionContent, depth); err != nil {
+			return err
+		}
+	}
+	f.endLine()
+	return f.w.Flush()
+}
+
+//WriteSource writes preprocessed program as assembler source
+func WriteSource(w io.Writer, prog Program) error {
+	return Format(w, prog)
+}
+
+//formatter - state of Format, line is written when the next one starts
+//so trailing comment can be appended to it
+type formatter struct {
+	w       *bufio.Writer
+	line    string
+	comment string
+	linePos Pos
+	open    bool
+	written bool
+	blank   bool
+	//lastPos is position of the last line taken from source,
+	//gaps between source lines are kept as single blank lines
+	lastPos Pos
+}
+
+func (f *formatter) endLine() {
+	if !f.open {
+		return
+	}
+	line := f.line
+	if f.comment != "" {
+		if pad := commentColumn - len(line); pad > 0 && line != "" {
+			line += strings.Repeat(" ", pad)
+		} else if line != "" {
+			line += " "
+		}
+		line += f.comment
+	}
+	f.w.WriteString(strings.TrimRight(line, " ") + "\n")
+	f.line, f.comment, f.open = "", "", false
+	f.written, f.blank = true, false
+}
+
+func (f *formatter) blankLine() {
+	f.endLine()
+	if f.written && !f.blank {
+		f.w.WriteString("\n")
+		f.blank = true
+	}
+}
+
+//startLine begins new line, pos is source position of its statement
+func (f *formatter) startLine(pos Pos, text string) {
+	f.endLine()
+	if pos.IsValid() {
+		if f.lastPos.IsValid() && pos.File == f.lastPos.File && pos.Line > f.lastPos.Line+1 {
+			f.blankLine()
+		}
+		f.lastPos = pos
+		f.lastPos.Line += strings.Count(text, "\n")
+	} else if f.lastPos.IsValid() {
+		//closing directives are not kept in tree, assume the next line
+		f.lastPos.Line++
+	}
+	f.line, f.linePos, f.open = text, pos, true
+}
+
+//stmtLine begins line of statement with aligned operands
+func (f *formatter) stmtLine(pos Pos, depth int, mnemonic string, operands string) {
+	text := strings.Repeat(" ", depth*indentWidth) + mnemonic
+	if operands != "" {
+		pad := mnemonicWidth - len(mnemonic)
+		if pad < 1 {
+			pad = 1
+		}
+		text += strings.Repeat(" ", pad) + operands
+	}
+	f.startLine(pos, text)
+}
+
+func (f *formatter) block(blk Block, depth int) error {
+	for _, stmt := range blk.elements {
+		if err := f.stmt(stmt, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *formatter) stmt(stmt Stmt, depth int) error {
+	switch v := stmt.(type) {
+	case Comment:
+		if v.trailing && f.open && f.comment == "" && sameLine(v.pos, f.linePos) {
+			f.comment = v.text
+			return nil
+		}
+		f.startLine(v.pos, strings.Repeat(" ", depth*indentWidth)+v.text)
+	case Label:
+		f.startLine(v.pos, identString(v)+":")
+	case Ifdef:
+		f.stmtLine(v.pos, depth, "#ifdef", identString(v.definition))
+		return f.branches(v.bodyTrue, v.bodyFalse, depth)
+	case Ifndef:
+		f.stmtLine(v.pos, depth, "#ifndef", identString(v.definition))
+		return f.branches(v.bodyTrue, v.bodyFalse, depth)
+	case If:
+		f.stmtLine(v.pos, depth, "#if", identString(v.condition))
+		return f.branches(v.bodyTrue, v.bodyFalse, depth)
+	case Macro:
+		f.stmtLine(v.pos, depth, "#macro", strings.TrimSpace(v.macroName+" "+paramsString(v)))
+		f.comment = v.comment
+		if err := f.block(v.body, depth+1); err != nil {
+			return err
+		}
+		f.stmtLine(Pos{}, depth, "#endmacro", "")
+	case For:
+		f.stmtLine(v.pos, depth, "#for", identString(v.variable)+" in "+joinOperands(", ", v.values...))
+		return f.loopBody(v.body, depth, "#endfor")
+	case Rept:
+		f.stmtLine(v.pos, depth, "#rept", joinOperands(", ", v.count, v.variable))
+		return f.loopBody(v.body, depth, "#endrept")
+	case While:
+		f.stmtLine(v.pos, depth, "#while", identString(v.condition))
+		return f.loopBody(v.body, depth, "#endwhile")
+	default:
+		mnemonic, operands, ok := stmtSource(stmt)
+		if !ok {
+			return errorAt(posOf(stmt), "cannot write %T as source", stmt)
+		}
+		f.stmtLine(posOf(stmt), depth, mnemonic, operands)
+	}
+	return nil
+}
+
+//loopBody writes body of loop directive and its closing directive
+func (f *formatter) loopBody(body Block, depth int, end string) error {
+	if err := f.block(body, depth+1); err != nil {
+		return err
+	}
+	f.stmtLine(Pos{}, depth, end, "")
+	return nil
+}
+
+//branches writes bodies of conditional directive, #elif chain is
+//written flat
+func (f *formatter) branches(bodyTrue Block, bodyFalse Block, depth int) error {
+	if err := f.block(bodyTrue, depth+1); err != nil {
+		return err
+	}
+	for len(bodyFalse.elements) != 0 {
+		if elif, ok := bodyFalse.elements[0].(If); ok && elif.elif && len(bodyFalse.elements) == 1 {
+			f.stmtLine(elif.pos, depth, "#elif", identString(elif.condition))
+			if err := f.block(elif.bodyTrue, depth+1); err != nil {
+				return err
+			}
+			bodyFalse = elif.bodyFalse
+			continue
+		}
+		f.stmtLine(Pos{}, depth, "#else", "")
+		if err := f.block(bodyFalse, depth+1); err != nil {
+			return err
+		}
+		break
+	}
+	f.stmtLine(Pos{}, depth, "#endif", "")
+	return nil
+}
+
+//stmtSource splits single line statement into mnemonic and operands
+func stmtSource(stmt Stmt) (mnemonic string, operands string, ok bool) {
+	switch v := stmt.(type) {
+	case Define:
+		return "#define", joinOperands(" ", v.name, v.definition), true
+	case Undef:
+		return "#undef", identString(v.definition), true
+	case Import:
+		return "#import", identString(v.name), true
+	case Line:
+		return "#line", joinOperands(" ", v.lineNumber, v.name), true
+	case Pragma:
+		return "#pragma", strings.Join(append([]string{v.name}, v.args...), " "), true
+	case Warn:
+		return "#warn", identString(v.message), true
+	case Error:
+		return "#error", identString(v.message), true
+	case Pext:
+		return "#pext", joinOperands(" ", v.pextName, v.pextAddress), true
+	case Sumdef:
+		return "#sumdef", joinOperands(" ", v.def1, v.def2), true
+	case Resdef:
+		return "#resdef", joinOperands(" ", v.def1, v.def2), true
+	case Return:
+		return "#return", identString(v.returnValue), true
+	case MacroCall:
+		return v.macroName, joinOperands(", ", v.args...), true
+	case Number, SimpleString:
+		return identString(v.(Ident)), "", true
+	case Data:
+		return dataMnemonic(v.kind), joinOperands(", ", v.values...), true
+	case Reserve:
+		return ".space", identString(v.size), true
+	case Opcode:
+		str := opcodeString(v)
+		if i := strings.Index(str, " "); i >= 0 {
+			return str[:i], str[i+1:], true
+		}
+		return str, "", true
+	}
+	return "", "", false
+}
+
+func joinOperands(sep string, ids ...Ident) string {
+	var strs []string
+	for _, id := range ids {
+		if id != nil {
+			strs = append(strs, identString(id))
+		}
+	}
+	return strings.Join(strs, sep)
+}
+
+//sameLine reports whether both positions are known and lie on one line.
+//Trailing comment of directive removed by preprocessor gets its own line
+func sameLine(pos1, pos2 Pos) bool {
+	return pos1.IsValid() && pos1.File == pos2.File && pos1.Line == pos2.Line
+}
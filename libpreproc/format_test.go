@@ -0,0 +1,94 @@
+package libpreproc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+//format parses source and formats it
+func format(t *testing.T, src string) string {
+	t.Helper()
+	prog, err := NewParser(strings.NewReader(src)).ParseFile()
+	if err != nil {
+		t.Fatalf("parse: %v\n%s", err, src)
+	}
+	var buf bytes.Buffer
+	if err := Format(&buf, prog); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+const unformatted = `; header
+#define   N 0x3
+section .text
+start:   add a,N   ; inc
+  mov b , a
+
+
+#ifdef N
+      out b
+#else
+   out 1
+#endif
+#macro twice x=1 ; doubles
+    #return x*2
+#endmacro
+#for i in 1..2
+    add a, i
+#endfor
+    jnc start
+section .data
+tbl: .byte 1,2 , "ab"
+`
+
+func TestFormat(t *testing.T) {
+	want := `; header
+#define N 0x3
+
+section .text
+start:
+    add     a, N                ; inc
+    mov     b, a
+
+    #ifdef  N
+        out     b
+    #else
+        out     1
+    #endif
+    #macro  twice x = 1         ; doubles
+        #return x * 2
+    #endmacro
+    #for    i in 1..2
+        add     a, i
+    #endfor
+    jnc     start
+
+section .data
+tbl:
+    .byte   1, 2, "ab"
+`
+	if got := format(t, unformatted); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormatIdempotent(t *testing.T) {
+	sources := []string{
+		unformatted,
+		"add a, 1\nsection .text\n    out 1 /* b */\n",
+		"section .text\n/* block\n   comment */\n    @@: jnc @b\n#if 1 + 2 > 2\n#elif defined(X)\n    out 2\n#endif\n",
+		"section .text\n#rept 2, i\n#while i < 1\n    #sumdef i 1\n#endwhile\n#endrept\n#macro emit v...\n#endmacro\n    emit 1, 2\n",
+	}
+	for _, src := range sources {
+		once := format(t, src)
+		if twice := format(t, once); twice != once {
+			t.Errorf("formatting is not idempotent\nonce\n%s\ntwice\n%s", once, twice)
+		}
+		//formatting doesn't change the program
+		if got, want := preprocess(t, once), preprocess(t, src); strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
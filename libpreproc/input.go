@@ -0,0 +1,160 @@
+package libpreproc
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//ReadImageFile reads memory image from file in specified format
+func ReadImageFile(filename string, format OutputFormat) (int, []byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+	return ReadImage(f, format)
+}
+
+//ReadImage reads memory image in specified format. It returns address of
+//the first byte and memory contents, gaps are filled with zeros
+func ReadImage(r io.Reader, format OutputFormat) (int, []byte, error) {
+	switch format {
+	case FormatBinary:
+		mem, err := ioutil.ReadAll(r)
+		return 0, mem, err
+	case FormatIntelHex:
+		return readIntelHex(r)
+	case FormatLogisim:
+		return readLogisim(r)
+	}
+	return 0, nil, fmt.Errorf("reading %s images is not supported", format)
+}
+
+//memoryBuilder - collects bytes written at arbitrary addresses
+type memoryBuilder struct {
+	cells map[int]byte
+	start int
+	end   int
+}
+
+func (m *memoryBuilder) write(addr int, data []byte) {
+	if m.cells == nil {
+		m.cells = make(map[int]byte)
+		m.start, m.end = addr, addr
+	}
+	if addr < m.start {
+		m.start = addr
+	}
+	if last := addr + len(data); last > m.end {
+		m.end = last
+	}
+	for i, d := range data {
+		m.cells[addr+i] = d
+	}
+}
+
+func (m *memoryBuilder) memory() (int, []byte) {
+	mem := make([]byte, m.end-m.start)
+	for addr, d := range m.cells {
+		mem[addr-m.start] = d
+	}
+	return m.start, mem
+}
+
+//readIntelHex reads data, end of file and extended address records
+func readIntelHex(r io.Reader) (int, []byte, error) {
+	var mem memoryBuilder
+	upper := 0
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if text[0] != ':' {
+			return 0, nil, fmt.Errorf("line %d: record has to start with ':'", line)
+		}
+		record, err := hex.DecodeString(text[1:])
+		if err != nil {
+			return 0, nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if len(record) < 5 || len(record) != int(record[0])+5 {
+			return 0, nil, fmt.Errorf("line %d: invalid record length", line)
+		}
+		var sum byte
+		for _, b := range record {
+			sum += b
+		}
+		if sum != 0 {
+			return 0, nil, fmt.Errorf("line %d: checksum mismatch", line)
+		}
+		addr := int(record[1])<<8 | int(record[2])
+		data := record[4 : len(record)-1]
+		switch record[3] {
+		case 0x00:
+			mem.write(upper+addr, data)
+		case 0x01:
+			start, data := mem.memory()
+			return start, data, nil
+		case 0x02:
+			if len(data) != 2 {
+				return 0, nil, fmt.Errorf("line %d: invalid extended segment address", line)
+			}
+			upper = (int(data[0])<<8 | int(data[1])) << 4
+		case 0x04:
+			if len(data) != 2 {
+				return 0, nil, fmt.Errorf("line %d: invalid extended linear address", line)
+			}
+			upper = (int(data[0])<<8 | int(data[1])) << 16
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, nil, err
+	}
+	return 0, nil, fmt.Errorf("no end of file record")
+}
+
+//readLogisim reads "v2.0 raw" image, values are hexadecimal, runs of
+//equal values are written as count*value, # starts comment
+func readLogisim(r io.Reader) (int, []byte, error) {
+	var words []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		words = append(words, strings.Fields(line)...)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, nil, err
+	}
+	if len(words) < 2 || words[0] != "v2.0" || words[1] != "raw" {
+		return 0, nil, fmt.Errorf("logisim image has to start with \"v2.0 raw\"")
+	}
+	var mem []byte
+	for _, word := range words[2:] {
+		count := 1
+		if i := strings.Index(word, "*"); i >= 0 {
+			n, err := strconv.Atoi(word[:i])
+			if err != nil || n < 0 {
+				return 0, nil, fmt.Errorf("invalid run length %q", word)
+			}
+			count, word = n, word[i+1:]
+		}
+		value, err := strconv.ParseUint(word, 16, 8)
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid value %q", word)
+		}
+		for i := 0; i < count; i++ {
+			mem = append(mem, byte(value))
+		}
+	}
+	return 0, mem, nil
+}
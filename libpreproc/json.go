@@ -0,0 +1,913 @@
+package libpreproc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+//Tree is serialized as JSON objects with "kind" member holding Kind name,
+//"pos" member holding position if it is known and members named after
+//accessors of the node. Omitted operands and register RegNone are left
+//out, registers are "a", "b" and "pc", operators are written as in source
+
+//field - member of JSON object
+type field struct {
+	key   string
+	value interface{}
+}
+
+//object - JSON object keeping order of members
+type object []field
+
+func (o object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range o {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(f.key)
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+//add appends member, nil operands, RegNone and empty optional values
+//are left out
+func (o object) add(key string, value interface{}) object {
+	switch v := value.(type) {
+	case nil:
+		return o
+	case Reg:
+		if v == nr {
+			return o
+		}
+		value = v.String()
+	case Token:
+		value = tokenName(v)
+	case Pos:
+		if !v.IsValid() {
+			return o
+		}
+	case []Ident:
+		if v == nil {
+			value = []Ident{}
+		}
+	case []Stmt:
+		if v == nil {
+			value = []Stmt{}
+		}
+	case []string:
+		if v == nil {
+			value = []string{}
+		}
+	}
+	return append(o, field{key, value})
+}
+
+func marshalNode(n Node) ([]byte, error) {
+	return json.Marshal(nodeObject(n))
+}
+
+//nodeObject returns members of node in schema order
+func nodeObject(n Node) object {
+	o := object{{"kind", n.Kind().String()}}.add("pos", n.Pos())
+	switch v := n.(type) {
+	case Program:
+		sections := v.sections
+		if sections == nil {
+			sections = []Section{}
+		}
+		o = o[:1].add("sections", sections)
+		if v.arch != "" {
+			o = o.add("arch", v.arch)
+		}
+	case Section:
+		o = o.add("name", v.sectionName)
+		if v.align != 0 {
+			o = o.add("align", v.align)
+		}
+		o = o.add("body", v.sectionContent)
+	case Block:
+		o = o[:1].add("stmts", v.elements)
+	case SimpleString:
+		o = o.add("value", v.value)
+	case Number:
+		o = o.add("value", v.value)
+		if v.lit != "" {
+			o = o.add("literal", v.lit)
+		}
+	case Variable:
+		o = o.add("name", v.name)
+	case Binary:
+		o = o.add("op", v.op).add("x", v.x).add("y", v.y)
+	case Unary:
+		o = o.add("op", v.op).add("x", v.x)
+	case Call:
+		o = o.add("function", v.function).add("args", v.args)
+	case Define:
+		o = o.add("name", v.name).add("value", v.definition)
+	case Import:
+		o = o.add("path", v.name)
+	case Line:
+		o = o.add("number", v.lineNumber).add("file", v.name)
+	case Pragma:
+		o = o.add("name", v.name).add("args", v.args)
+	case Warn:
+		o = o.add("message", v.message)
+	case Error:
+		o = o.add("message", v.message)
+	case Sumdef:
+		o = o.add("name", v.def1).add("operand", v.def2)
+	case Resdef:
+		o = o.add("name", v.def1).add("operand", v.def2)
+	case Pext:
+		o = o.add("name", v.pextName).add("address", v.pextAddress)
+	case Undef:
+		o = o.add("name", v.definition)
+	case Ifdef:
+		o = o.add("name", v.definition).add("then", v.bodyTrue).add("else", v.bodyFalse)
+	case Ifndef:
+		o = o.add("name", v.definition).add("then", v.bodyTrue).add("else", v.bodyFalse)
+	case If:
+		o = o.add("cond", v.condition).add("then", v.bodyTrue).add("else", v.bodyFalse)
+		if v.elif {
+			o = o.add("elif", true)
+		}
+	case Macro:
+		o = o.add("name", v.macroName).add("params", v.args)
+		if v.defaults != nil {
+			o = o.add("defaults", v.defaults)
+		}
+		if v.variadic {
+			o = o.add("variadic", true)
+		}
+		if v.comment != "" {
+			o = o.add("comment", v.comment)
+		}
+		o = o.add("body", v.body)
+	case For:
+		o = o.add("var", v.variable).add("values", v.values).add("body", v.body)
+	case Rept:
+		o = o.add("count", v.count).add("var", v.variable).add("body", v.body)
+	case While:
+		o = o.add("cond", v.condition).add("body", v.body)
+	case Return:
+		o = o.add("value", v.returnValue)
+	case MacroCall:
+		o = o.add("name", v.macroName).add("args", v.args)
+	case Label:
+		o = o.add("name", v.name)
+	case Data:
+		o = o.add("type", v.kind).add("values", v.values)
+	case Reserve:
+		o = o.add("size", v.size)
+	case Comment:
+		o = o.add("text", v.text)
+		if v.trailing {
+			o = o.add("trailing", true)
+		}
+	case Add:
+		o = o.add("reg", v.reg).add("value", v.value)
+	case Mov:
+		o = o.add("dst", v.reg1).add("src", v.reg2).add("value", v.fa)
+	case In:
+		o = o.add("reg", v.reg).add("value", v.fa)
+	case Out:
+		o = o.add("reg", v.reg).add("value", v.fa)
+	case Cmp:
+		o = o.add("regA", v.regA).add("regB", v.regB).add("operation", v.operation)
+	case Jmp:
+		o = o.add("reg", v.regB).add("addr", v.addr)
+	case Jnc:
+		o = o.add("reg", v.regB).add("addr", v.addr)
+	}
+	return o
+}
+
+//tokenName returns operator as in source or data type name
+func tokenName(tok Token) string {
+	switch tok {
+	case NIBBLE:
+		return "nibble"
+	case BYTE:
+		return "byte"
+	case STRING:
+		return "string"
+	}
+	return tok.String()
+}
+
+func parseTokenName(name string) (Token, bool) {
+	for _, tok := range []Token{NIBBLE, BYTE, STRING} {
+		if tokenName(tok) == name {
+			return tok, true
+		}
+	}
+	for tok, op := range operators {
+		if op == name {
+			return tok, true
+		}
+	}
+	return ILLEGAL, false
+}
+
+func parseReg(name string) (Reg, bool) {
+	for _, r := range []Reg{a, b, pc} {
+		if r.String() == name {
+			return r, true
+		}
+	}
+	return nr, false
+}
+
+//MarshalJSON encodes program with kind discriminator
+func (v Program) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes program
+func (v *Program) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes section with kind discriminator
+func (v Section) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes section
+func (v *Section) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes block with kind discriminator
+func (v Block) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes block
+func (v *Block) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes simplestring with kind discriminator
+func (v SimpleString) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes simplestring
+func (v *SimpleString) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes number with kind discriminator
+func (v Number) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes number
+func (v *Number) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes variable with kind discriminator
+func (v Variable) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes variable
+func (v *Variable) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes binary with kind discriminator
+func (v Binary) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes binary
+func (v *Binary) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes unary with kind discriminator
+func (v Unary) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes unary
+func (v *Unary) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes call with kind discriminator
+func (v Call) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes call
+func (v *Call) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes location with kind discriminator
+func (v Location) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes location
+func (v *Location) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes define with kind discriminator
+func (v Define) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes define
+func (v *Define) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes import with kind discriminator
+func (v Import) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes import
+func (v *Import) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes line with kind discriminator
+func (v Line) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes line
+func (v *Line) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes pragma with kind discriminator
+func (v Pragma) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes pragma
+func (v *Pragma) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes warn with kind discriminator
+func (v Warn) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes warn
+func (v *Warn) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes sumdef with kind discriminator
+func (v Sumdef) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes sumdef
+func (v *Sumdef) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes resdef with kind discriminator
+func (v Resdef) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes resdef
+func (v *Resdef) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes pext with kind discriminator
+func (v Pext) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes pext
+func (v *Pext) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes error with kind discriminator
+func (v Error) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes error
+func (v *Error) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes undef with kind discriminator
+func (v Undef) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes undef
+func (v *Undef) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes ifdef with kind discriminator
+func (v Ifdef) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes ifdef
+func (v *Ifdef) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes ifndef with kind discriminator
+func (v Ifndef) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes ifndef
+func (v *Ifndef) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes if with kind discriminator
+func (v If) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes if
+func (v *If) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes macro with kind discriminator
+func (v Macro) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes macro
+func (v *Macro) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes for with kind discriminator
+func (v For) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes for
+func (v *For) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes rept with kind discriminator
+func (v Rept) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes rept
+func (v *Rept) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes while with kind discriminator
+func (v While) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes while
+func (v *While) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes return with kind discriminator
+func (v Return) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes return
+func (v *Return) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes macro call with kind discriminator
+func (v MacroCall) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes macro call
+func (v *MacroCall) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes label with kind discriminator
+func (v Label) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes label
+func (v *Label) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes data with kind discriminator
+func (v Data) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes data
+func (v *Data) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes reserve with kind discriminator
+func (v Reserve) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes reserve
+func (v *Reserve) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes comment with kind discriminator
+func (v Comment) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes comment
+func (v *Comment) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes add with kind discriminator
+func (v Add) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes add
+func (v *Add) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes mov with kind discriminator
+func (v Mov) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes mov
+func (v *Mov) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes in with kind discriminator
+func (v In) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes in
+func (v *In) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes out with kind discriminator
+func (v Out) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes out
+func (v *Out) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes cmp with kind discriminator
+func (v Cmp) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes cmp
+func (v *Cmp) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes jmp with kind discriminator
+func (v Jmp) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes jmp
+func (v *Jmp) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//MarshalJSON encodes jnc with kind discriminator
+func (v Jnc) MarshalJSON() ([]byte, error) {
+	return marshalNode(v)
+}
+
+//UnmarshalJSON decodes jnc
+func (v *Jnc) UnmarshalJSON(data []byte) error {
+	return unmarshalNode(data, v)
+}
+
+//UnmarshalNode decodes node of any kind
+func UnmarshalNode(data []byte) (Node, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if fields == nil {
+		return nil, fmt.Errorf("node expected, met null")
+	}
+	d := &nodeDecoder{fields: fields}
+	name := d.str("kind")
+	kind, ok := ParseKind(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown node kind %q", name)
+	}
+	pos := d.pos()
+	var n Node
+	switch kind {
+	case KindProgram:
+		var sections []Section
+		d.decode("sections", &sections)
+		n = Program{sections: sections, arch: d.str("arch")}
+	case KindSection:
+		n = Section{sectionName: d.str("name"), sectionContent: d.block("body"), align: d.integer("align"), pos: pos}
+	case KindBlock:
+		n = Block{elements: d.stmts("stmts")}
+	case KindSimpleString:
+		n = SimpleString{value: d.str("value"), pos: pos}
+	case KindNumber:
+		n = Number{value: d.integer("value"), lit: d.str("literal"), pos: pos}
+	case KindVariable:
+		n = Variable{name: d.str("name"), pos: pos}
+	case KindBinary:
+		n = Binary{op: d.token("op"), x: d.ident("x"), y: d.ident("y"), pos: pos}
+	case KindUnary:
+		n = Unary{op: d.token("op"), x: d.ident("x"), pos: pos}
+	case KindCall:
+		n = Call{function: d.str("function"), args: d.idents("args"), pos: pos}
+	case KindLocation:
+		n = Location{pos: pos}
+	case KindDefine:
+		n = Define{name: d.ident("name"), definition: d.ident("value"), pos: pos}
+	case KindImport:
+		n = Import{name: d.ident("path"), pos: pos}
+	case KindLine:
+		n = Line{lineNumber: d.ident("number"), name: d.ident("file"), pos: pos}
+	case KindPragma:
+		n = Pragma{name: d.str("name"), args: d.strs("args"), pos: pos}
+	case KindWarn:
+		n = Warn{message: d.ident("message"), pos: pos}
+	case KindError:
+		n = Error{message: d.ident("message"), pos: pos}
+	case KindSumdef:
+		n = Sumdef{def1: d.ident("name"), def2: d.ident("operand"), pos: pos}
+	case KindResdef:
+		n = Resdef{def1: d.ident("name"), def2: d.ident("operand"), pos: pos}
+	case KindPext:
+		n = Pext{pextName: d.ident("name"), pextAddress: d.ident("address"), pos: pos}
+	case KindUndef:
+		n = Undef{definition: d.ident("name"), pos: pos}
+	case KindIfdef:
+		n = Ifdef{definition: d.ident("name"), bodyTrue: d.block("then"), bodyFalse: d.block("else"), pos: pos}
+	case KindIfndef:
+		n = Ifndef{definition: d.ident("name"), bodyTrue: d.block("then"), bodyFalse: d.block("else"), pos: pos}
+	case KindIf:
+		n = If{condition: d.ident("cond"), bodyTrue: d.block("then"), bodyFalse: d.block("else"), elif: d.boolean("elif"), pos: pos}
+	case KindMacro:
+		n = Macro{macroName: d.str("name"), args: d.strs("params"), defaults: d.optIdents("defaults"), variadic: d.boolean("variadic"), comment: d.str("comment"), body: d.block("body"), pos: pos}
+	case KindFor:
+		n = For{variable: d.ident("var"), values: d.idents("values"), body: d.block("body"), pos: pos}
+	case KindRept:
+		n = Rept{count: d.ident("count"), variable: d.ident("var"), body: d.block("body"), pos: pos}
+	case KindWhile:
+		n = While{condition: d.ident("cond"), body: d.block("body"), pos: pos}
+	case KindReturn:
+		n = Return{returnValue: d.ident("value"), pos: pos}
+	case KindMacroCall:
+		n = MacroCall{macroName: d.str("name"), args: d.idents("args"), pos: pos}
+	case KindLabel:
+		n = Label{name: d.ident("name"), pos: pos}
+	case KindData:
+		n = Data{kind: d.token("type"), values: d.idents("values"), pos: pos}
+	case KindReserve:
+		n = Reserve{size: d.ident("size"), pos: pos}
+	case KindComment:
+		n = Comment{text: d.str("text"), trailing: d.boolean("trailing"), pos: pos}
+	case KindAdd:
+		n = Add{reg: d.reg("reg"), value: d.ident("value"), pos: pos}
+	case KindMov:
+		n = Mov{reg1: d.reg("dst"), reg2: d.reg("src"), fa: d.ident("value"), pos: pos}
+	case KindIn:
+		n = In{reg: d.reg("reg"), fa: d.ident("value"), pos: pos}
+	case KindOut:
+		n = Out{reg: d.reg("reg"), fa: d.ident("value"), pos: pos}
+	case KindCmp:
+		n = Cmp{regA: d.reg("regA"), regB: d.reg("regB"), operation: d.ident("operation"), pos: pos}
+	case KindJmp:
+		n = Jmp{regB: d.reg("reg"), addr: d.ident("addr"), pos: pos}
+	case KindJnc:
+		n = Jnc{regB: d.reg("reg"), addr: d.ident("addr"), pos: pos}
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("%s: %v", kind, d.err)
+	}
+	return n, nil
+}
+
+//unmarshalNode decodes node into v, which has to point to node of the
+//same type
+func unmarshalNode(data []byte, v interface{}) error {
+	n, err := UnmarshalNode(data)
+	if err != nil {
+		return err
+	}
+	dst := reflect.ValueOf(v).Elem()
+	if reflect.TypeOf(n) != dst.Type() {
+		return fmt.Errorf("cannot decode %s into %s", n.Kind(), dst.Type().Name())
+	}
+	dst.Set(reflect.ValueOf(n))
+	return nil
+}
+
+//nodeDecoder - reads members of JSON object, the first error is kept
+type nodeDecoder struct {
+	fields map[string]json.RawMessage
+	err    error
+}
+
+//decode decodes member into v, missing member leaves v unchanged
+func (d *nodeDecoder) decode(key string, v interface{}) {
+	raw, ok := d.fields[key]
+	if !ok || d.err != nil {
+		return
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		d.err = fmt.Errorf("%s: %v", key, err)
+	}
+}
+
+func (d *nodeDecoder) fail(key string, format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...))
+	}
+}
+
+func (d *nodeDecoder) str(key string) string {
+	var s string
+	d.decode(key, &s)
+	return s
+}
+
+func (d *nodeDecoder) integer(key string) int {
+	var i int
+	d.decode(key, &i)
+	return i
+}
+
+func (d *nodeDecoder) boolean(key string) bool {
+	var b bool
+	d.decode(key, &b)
+	return b
+}
+
+func (d *nodeDecoder) pos() Pos {
+	var pos Pos
+	d.decode("pos", &pos)
+	return pos
+}
+
+func (d *nodeDecoder) reg(key string) Reg {
+	if _, ok := d.fields[key]; !ok {
+		return nr
+	}
+	name := d.str(key)
+	r, ok := parseReg(name)
+	if !ok {
+		d.fail(key, "unknown register %q", name)
+	}
+	return r
+}
+
+func (d *nodeDecoder) token(key string) Token {
+	name := d.str(key)
+	tok, ok := parseTokenName(name)
+	if !ok {
+		d.fail(key, "unknown operator %q", name)
+	}
+	return tok
+}
+
+func (d *nodeDecoder) block(key string) Block {
+	var blk Block
+	d.decode(key, &blk)
+	return blk
+}
+
+//node decodes member holding node, null and missing member give nil
+func (d *nodeDecoder) node(key string, raw json.RawMessage) Node {
+	if string(raw) == "null" || d.err != nil {
+		return nil
+	}
+	n, err := UnmarshalNode(raw)
+	if err != nil {
+		d.fail(key, "%v", err)
+	}
+	return n
+}
+
+func (d *nodeDecoder) ident(key string) Ident {
+	raw, ok := d.fields[key]
+	if !ok {
+		return nil
+	}
+	return d.asIdent(key, d.node(key, raw))
+}
+
+func (d *nodeDecoder) asIdent(key string, n Node) Ident {
+	if n == nil {
+		return nil
+	}
+	id, ok := n.(Ident)
+	if !ok {
+		d.fail(key, "%s is not an operand", n.Kind())
+	}
+	return id
+}
+
+//strs decodes list of strings, empty list is nil as parser leaves it
+func (d *nodeDecoder) strs(key string) []string {
+	var strs []string
+	d.decode(key, &strs)
+	if len(strs) == 0 {
+		return nil
+	}
+	return strs
+}
+
+func (d *nodeDecoder) idents(key string) []Ident {
+	var raws []json.RawMessage
+	d.decode(key, &raws)
+	var ids []Ident
+	for _, raw := range raws {
+		if id := d.asIdent(key, d.node(key, raw)); id != nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+//optIdents decodes list of optional operands, null items are kept as nil
+func (d *nodeDecoder) optIdents(key string) []Ident {
+	var raws []json.RawMessage
+	d.decode(key, &raws)
+	if raws == nil {
+		return nil
+	}
+	ids := make([]Ident, len(raws))
+	for i, raw := range raws {
+		ids[i] = d.asIdent(key, d.node(key, raw))
+	}
+	return ids
+}
+
+func (d *nodeDecoder) stmts(key string) []Stmt {
+	var raws []json.RawMessage
+	d.decode(key, &raws)
+	var stmts []Stmt
+	for _, raw := range raws {
+		n := d.node(key, raw)
+		if n == nil {
+			continue
+		}
+		stmt, ok := n.(Stmt)
+		if !ok {
+			d.fail(key, "%s is not a statement", n.Kind())
+			continue
+		}
+		stmts = append(stmts, stmt)
+	}
+	return stmts
+}
@@ -0,0 +1,71 @@
+package libpreproc
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	sources := []string{
+		unformatted,
+		"section .text\n#line 10 \"a.s\"\n#pragma arch td4e\n#import \"x.s\"\n#warn __LINE__\n#error \"e\"\n#pext io 12\n#undef N\n",
+		"section .text\n#ifndef X\n#if $ > 1 && defined(Y)\n    mov a, b, 1\n#elif 1\n    cmp a, b, 2\n#endif\n#endif\n",
+		"section .text\n#macro m x, v...\n    #return hi(x) + -x\n#endmacro\n    in b, m(1, 2, 3)\n    out 1\n    jnc b\n    @@: jmp @b\n    .space 2\n    .nibble 1\n",
+		"section .text\n#rept 2, i\n#while i < 1\n    #sumdef i 1\n    #resdef i 1\n#endwhile\n#endrept\n",
+	}
+	for _, src := range sources {
+		prog, err := NewParser(strings.NewReader(src)).ParseFile()
+		if err != nil {
+			t.Fatalf("parse: %v\n%s", err, src)
+		}
+		data, err := json.Marshal(prog)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var back Program
+		if err := json.Unmarshal(data, &back); err != nil {
+			t.Fatalf("unmarshal: %v\n%s", err, data)
+		}
+		if !reflect.DeepEqual(prog, back) {
+			t.Errorf("decoded program differs\n%s", src)
+		}
+		again, err := json.Marshal(back)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, again) {
+			t.Errorf("got\n%s\nwant\n%s", again, data)
+		}
+	}
+}
+
+func TestUnmarshalNode(t *testing.T) {
+	n, err := UnmarshalNode([]byte(`{"kind":"binary","op":"<<","x":{"kind":"number","value":1},"y":{"kind":"location"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := identString(n.(Ident)); got != "1 << $" {
+		t.Errorf("got %s, want 1 << $", got)
+	}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`{"kind":"nope"}`, `unknown node kind "nope"`},
+		{`null`, "node expected, met null"},
+		{`{"kind":"add","reg":"q","value":{"kind":"number","value":1}}`, `add: reg: unknown register "q"`},
+		{`{"kind":"number","value":"x"}`, "number: value: json: cannot unmarshal string into Go value of type int"},
+	}
+	for _, tt := range tests {
+		if _, err := UnmarshalNode([]byte(tt.src)); err == nil || err.Error() != tt.want {
+			t.Errorf("%s: got error %v, want %q", tt.src, err, tt.want)
+		}
+	}
+	var mov Mov
+	if err := json.Unmarshal([]byte(`{"kind":"add","reg":"a"}`), &mov); err == nil || err.Error() != "cannot decode add into Mov" {
+		t.Errorf("got error %v, want cannot decode add into Mov", err)
+	}
+}
@@ -0,0 +1,82 @@
+package libpreproc
+
+import (
+	"fmt"
+	"strings"
+)
+
+//Labels renamed by preprocessor
+const (
+	//localPrefix starts label local to macro expansion, %%loop
+	localPrefix = "%%"
+	//anonLabel - anonymous label, referred to by @f and @b
+	anonLabel = "@@"
+	//anonForward refers to the next anonymous label
+	anonForward = "@f"
+	//anonBackward refers to the previous anonymous label
+	anonBackward = "@b"
+)
+
+//isLocalLabel reports whether name is local to macro
+func isLocalLabel(name string) bool {
+	return strings.HasPrefix(name, localPrefix)
+}
+
+//isScopedLabel reports whether name is renamed by preprocessor
+func isScopedLabel(name string) bool {
+	switch name {
+	case anonLabel, anonForward, anonBackward:
+		return true
+	}
+	return isLocalLabel(name)
+}
+
+//anonName returns name of n-th anonymous label
+func anonName(n int) string {
+	return fmt.Sprintf("anon.%d", n)
+}
+
+//renameLabel gives local and anonymous labels their unique names. Local
+//label %%loop of macro delay becomes delay.loop.N where N is number of
+//the expansion, anonymous labels are numbered in order of definition.
+//define is true for label definitions and false for references
+func (pp *Preprocessor) renameLabel(l Label, define bool) (Label, error) {
+	name, err := definitionName(l)
+	if err != nil || !isScopedLabel(name) {
+		return l, err
+	}
+	switch {
+	case isLocalLabel(name):
+		if len(pp.calls) == 0 {
+			return l, errorAt(l.pos, "local label %s outside of macro", name)
+		}
+		macro := pp.calls[len(pp.calls)-1].Name
+		name = fmt.Sprintf("%s.%s.%d", macro, strings.TrimPrefix(name, localPrefix), pp.expansion)
+	case name == anonLabel:
+		if !define {
+			return l, errorAt(l.pos, "%s cannot be referenced, use %s or %s", anonLabel, anonForward, anonBackward)
+		}
+		pp.anons++
+		name = anonName(pp.anons)
+	case define:
+		return l, errorAt(l.pos, "%s cannot be defined, use %s", name, anonLabel)
+	case name == anonBackward:
+		if pp.anons == 0 {
+			return l, errorAt(l.pos, "no %s label before %s", anonLabel, anonBackward)
+		}
+		name = anonName(pp.anons)
+	case name == anonForward:
+		pp.forward = l.pos
+		pp.forwardTo = pp.anons + 1
+		name = anonName(pp.forwardTo)
+	}
+	return Label{name: Variable{name: name, pos: l.pos}, pos: l.pos}, nil
+}
+
+//checkForward returns error if the last @f has no anonymous label after it
+func (pp *Preprocessor) checkForward() error {
+	if pp.forwardTo > pp.anons {
+		return errorAt(pp.forward, "no %s label after %s", anonLabel, anonForward)
+	}
+	return nil
+}
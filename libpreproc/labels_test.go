@@ -0,0 +1,51 @@
+package libpreproc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLocalLabels(t *testing.T) {
+	src := `section .text
+#macro delay n
+    mov a, n
+%%loop:
+    add a, 15
+    jnc %%loop
+#endmacro
+    delay 2
+    delay 3
+`
+	want := "section .text\nmov a, 2\ndelay.loop.1:\nadd a, 15\njnc delay.loop.1\nmov a, 3\ndelay.loop.2:\nadd a, 15\njnc delay.loop.2"
+	if got := sourceLines(t, processSource(t, src)); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestAnonymousLabels(t *testing.T) {
+	src := "section .text\n@@: in a\n    jnc @f\n    jmp @b\n@@: out b\n    jmp @b\n"
+	want := "section .text\nanon.1:\nin a\njnc anon.2\njmp anon.1\nanon.2:\nout b\njmp anon.2"
+	if got := sourceLines(t, processSource(t, src)); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestLabelErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"    jmp %%x", "2:9: local label %%x outside of macro"},
+		{"    jnc @f", "2:9: no @@ label after @f"},
+		{"    jmp @b", "2:9: no @@ label before @b"},
+	}
+	for _, tt := range tests {
+		prog, err := NewParser(strings.NewReader("section .text\n" + tt.src + "\n")).ParseFile()
+		if err == nil {
+			_, err = NewPreprocessor().Process(prog)
+		}
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%q: got error %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"sort"
 )
 
 //LinkerScript - representation of linker information
@@ -36,6 +37,18 @@ func OpenLinkerScript(filename string) (LinkerScript, error) {
 	return script, nil
 }
 
+//DefaultLinkerScript returns script used when no script is given:
+//TD4 with 16 bytes of ROM holding .text and .data sections
+func DefaultLinkerScript() LinkerScript {
+	return LinkerScript{
+		ARCHITECTURE: "td4",
+		SEARCHDIR:    "./",
+		OUTPUT:       "a.bin",
+		MEMORY:       map[string]MemoryPartition{"ROM": {ORIGIN: 0, LENGTH: 16}},
+		SECTIONS:     map[string][]string{"ROM": {".text", ".data"}},
+	}
+}
+
 //GetPartitionList returns memory partition list
 func (l *LinkerScript) GetPartitionList() ([]string, error) {
 	if l == nil {
@@ -57,3 +70,147 @@ func (l *LinkerScript) GetPartitionSections(partition string) ([]string, error)
 	}
 	return l.SECTIONS[partition], nil
 }
+
+//Segment - contents of one memory partition after linking
+type Segment struct {
+	partition string
+	origin    int
+	data      []byte
+}
+
+//Partition returns name of memory partition
+func (s Segment) Partition() string {
+	return s.partition
+}
+
+//Origin returns address of the first byte of segment
+func (s Segment) Origin() int {
+	return s.origin
+}
+
+//Data returns machine code and data placed into the partition
+func (s Segment) Data() []byte {
+	return s.data
+}
+
+//Image - linked program
+type Image struct {
+	segments []Segment
+	symbols  SymbolTable
+	entry    string
+}
+
+//Segments returns non-empty partitions ordered by origin
+func (img Image) Segments() []Segment {
+	return img.segments
+}
+
+//Symbols returns labels with their final addresses
+func (img Image) Symbols() SymbolTable {
+	return img.symbols
+}
+
+//Entry returns address of the entry label if it is defined
+func (img Image) Entry() (int, bool) {
+	sym, ok := img.symbols[img.entry]
+	return sym.address, ok
+}
+
+//Link places sections of preprocessed programs into memory partitions in
+//the order listed in SECTIONS and encodes them with final label addresses
+func (l *LinkerScript) Link(progs ...Program) (Image, error) {
+	if l == nil {
+		return Image{}, fmt.Errorf("no parsed linkre script")
+	}
+	var merged Program
+	for _, prog := range progs {
+		merged.sections = append(merged.sections, prog.sections...)
+	}
+	var profile *Profile
+	if l.ARCHITECTURE != "" {
+		var err error
+		if profile, err = LookupProfile(l.ARCHITECTURE); err != nil {
+			return Image{}, err
+		}
+	}
+	table, order, sizes, err := collectLabels(merged)
+	if err != nil {
+		return Image{}, err
+	}
+	aligns := make(map[string]int)
+	for _, section := range merged.sections {
+		if section.align > aligns[section.sectionName] {
+			aligns[section.sectionName] = section.align
+		}
+	}
+	partitions := l.sortedPartitions()
+	bases := make(map[string]int)
+	placed := make(map[string]string)
+	for _, partition := range partitions {
+		mem := l.MEMORY[partition]
+		base := mem.ORIGIN
+		for _, section := range l.SECTIONS[partition] {
+			if other, dup := placed[section]; dup {
+				return Image{}, fmt.Errorf("section %s is placed into both %s and %s", section, other, partition)
+			}
+			placed[section] = partition
+			if align := aligns[section]; align > 1 && base%align != 0 {
+				base += align - base%align
+			}
+			bases[section] = base
+			base += sizes[section]
+		}
+		if used := base - mem.ORIGIN; used > mem.LENGTH {
+			return Image{}, fmt.Errorf("partition %s overflow: %d bytes used, length is %d", partition, used, mem.LENGTH)
+		}
+	}
+	for _, section := range order {
+		if _, ok := placed[section]; !ok && sizes[section] != 0 {
+			return Image{}, fmt.Errorf("section %s is not placed into any partition", section)
+		}
+	}
+	table.relocate(bases)
+	resolved, err := table.resolveProgram(merged, bases)
+	if err != nil {
+		return Image{}, err
+	}
+	contents := make(map[string]Block)
+	for _, section := range resolved.sections {
+		blk := contents[section.sectionName]
+		blk.elements = append(blk.elements, section.sectionContent.elements...)
+		contents[section.sectionName] = blk
+	}
+	img := Image{symbols: table, entry: l.ENTRY}
+	for _, partition := range partitions {
+		seg := Segment{partition: partition, origin: l.MEMORY[partition].ORIGIN}
+		for _, section := range l.SECTIONS[partition] {
+			if profile != nil {
+				if err := profile.CheckBlock(bases[section], contents[section]); err != nil {
+					return Image{}, err
+				}
+			}
+			code, err := AssembleBlock(contents[section], table.Value)
+			if err != nil {
+				return Image{}, err
+			}
+			//gap left by alignment is filled with zeros
+			if gap := bases[section] - seg.origin - len(seg.data); gap > 0 && len(code) != 0 {
+				seg.data = append(seg.data, make([]byte, gap)...)
+			}
+			seg.data = append(seg.data, code...)
+		}
+		if len(seg.data) != 0 {
+			img.segments = append(img.segments, seg)
+		}
+	}
+	return img, nil
+}
+
+//sortedPartitions returns memory partitions ordered by origin
+func (l *LinkerScript) sortedPartitions() []string {
+	partitions, _ := l.GetPartitionList()
+	sort.Slice(partitions, func(i, j int) bool {
+		return l.MEMORY[partitions[i]].ORIGIN < l.MEMORY[partitions[j]].ORIGIN
+	})
+	return partitions
+}
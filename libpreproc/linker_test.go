@@ -0,0 +1,86 @@
+package libpreproc
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLink(t *testing.T) {
+	src := `section .text
+start:
+    mov a, x
+    jmp start
+section .data
+x: .byte 7
+`
+	tests := []struct {
+		name     string
+		memory   map[string]MemoryPartition
+		sections map[string][]string
+		src      string
+		want     []Segment
+	}{
+		{
+			"partitions",
+			map[string]MemoryPartition{"ROM": {ORIGIN: 0, LENGTH: 16}, "RAM": {ORIGIN: 8, LENGTH: 8}},
+			map[string][]string{"ROM": {".text"}, "RAM": {".data"}},
+			src,
+			[]Segment{{"ROM", 0, []byte{0x38, 0xF0}}, {"RAM", 8, []byte{7}}},
+		},
+		{
+			"order of sections",
+			map[string]MemoryPartition{"ROM": {ORIGIN: 0, LENGTH: 16}},
+			map[string][]string{"ROM": {".data", ".text"}},
+			src,
+			[]Segment{{"ROM", 0, []byte{7, 0x30, 0xF1}}},
+		},
+		{
+			"alignment gap",
+			map[string]MemoryPartition{"ROM": {ORIGIN: 0, LENGTH: 16}},
+			map[string][]string{"ROM": {".text", ".data"}},
+			src + "#pragma section-align 4\n",
+			[]Segment{{"ROM", 0, []byte{0x34, 0xF0, 0, 0, 7}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := LinkerScript{ENTRY: "start", MEMORY: tt.memory, SECTIONS: tt.sections}
+			img, err := script.Link(processSource(t, tt.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(img.Segments()) != len(tt.want) {
+				t.Fatalf("got %d segments, want %d", len(img.Segments()), len(tt.want))
+			}
+			for i, seg := range img.Segments() {
+				want := tt.want[i]
+				if seg.Partition() != want.partition || seg.Origin() != want.origin || !bytes.Equal(seg.Data(), want.data) {
+					t.Errorf("got %s %d % x, want %s %d % x", seg.Partition(), seg.Origin(), seg.Data(), want.partition, want.origin, want.data)
+				}
+			}
+			if entry, ok := img.Entry(); !ok || entry != img.Symbols()["start"].Address() {
+				t.Errorf("got entry %d %t", entry, ok)
+			}
+		})
+	}
+}
+
+func TestLinkErrors(t *testing.T) {
+	rom := map[string]MemoryPartition{"ROM": {ORIGIN: 0, LENGTH: 2}, "RAM": {ORIGIN: 8, LENGTH: 8}}
+	tests := []struct {
+		name     string
+		sections map[string][]string
+		want     string
+	}{
+		{"overflow", map[string][]string{"ROM": {".text", ".data"}}, "partition ROM overflow: 3 bytes used, length is 2"},
+		{"not placed", map[string][]string{"ROM": {".text"}}, "section .data is not placed into any partition"},
+		{"placed twice", map[string][]string{"ROM": {".text"}, "RAM": {".data", ".text"}}, "section .text is placed into both ROM and RAM"},
+	}
+	prog := processSource(t, "section .text\n    add a, 1\n    out b\nsection .data\n    .byte 1\n")
+	for _, tt := range tests {
+		script := LinkerScript{MEMORY: rom, SECTIONS: tt.sections}
+		if _, err := script.Link(prog); err == nil || err.Error() != tt.want {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
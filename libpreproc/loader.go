@@ -0,0 +1,161 @@
+package libpreproc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//Main returns program of the main file
+func (m Module) Main() Program {
+	return m.main
+}
+
+//Imports returns programs of files imported while parsing, dependencies go
+//first. Preprocessor evaluates only the imports it reaches
+func (m Module) Imports() []Program {
+	return m.imports
+}
+
+//loadedFile - parsed file with macros and labels it makes visible
+type loadedFile struct {
+	prog      Program
+	macroList []string
+	labelList []string
+}
+
+//Loader - loads source files resolving #import directives. Imports are
+//looked up relative to the importing file, then in SEARCH_DIR of linker
+//script, then in user include directories
+type Loader struct {
+	searchDirs []string
+	files      map[string]*loadedFile
+	loading    []string
+	imports    []Program
+}
+
+//NewLoader returns a new instance of Loader. script may be nil
+func NewLoader(script *LinkerScript, includeDirs []string) *Loader {
+	var dirs []string
+	if script != nil && script.SEARCHDIR != "" {
+		dirs = append(dirs, script.SEARCHDIR)
+	}
+	dirs = append(dirs, includeDirs...)
+	return &Loader{searchDirs: dirs, files: make(map[string]*loadedFile)}
+}
+
+//LoadModule parses file with all its imports
+func (l *Loader) LoadModule(filename string) (Module, error) {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return Module{}, err
+	}
+	main, err := l.load(filename)
+	if err != nil {
+		return Module{}, err
+	}
+	return Module{
+		macroList: main.macroList,
+		labelList: main.labelList,
+		main:      main.prog,
+		imports:   l.imports,
+		path:      path,
+		loader:    l,
+	}, nil
+}
+
+//load parses file once, nested imports are loaded by its parser
+func (l *Loader) load(filename string) (*loadedFile, error) {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	for i, loading := range l.loading {
+		if loading == path {
+			cycle := append(append([]string{}, l.loading[i:]...), path)
+			return nil, fmt.Errorf("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	if file, ok := l.files[path]; ok {
+		return file, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	l.loading = append(l.loading, path)
+	p := NewFileParser(f, filename)
+	p.loader = l
+	prog, err := p.ParseFile()
+	l.loading = l.loading[:len(l.loading)-1]
+	if err != nil {
+		return nil, err
+	}
+	file := &loadedFile{prog: prog, macroList: p.macroList, labelList: p.labelList}
+	l.files[path] = file
+	return file, nil
+}
+
+//importFile parses imported file and makes its macros and labels visible
+//to the importing parser. Errors are left to preprocessor, which reports
+//them if it reaches the #import, so a file imported under false condition
+//may be missing
+func (l *Loader) importFile(p *Parser, name string, from string) {
+	_, file, err := l.loadImport(name, from)
+	if err != nil {
+		return
+	}
+	for _, macro := range file.macroList {
+		if _, found := find(p.macroList, macro); !found {
+			p.macroList = append(p.macroList, macro)
+		}
+	}
+	for _, label := range file.labelList {
+		if _, found := find(p.labelList, label); !found {
+			p.labelList = append(p.labelList, label)
+		}
+	}
+}
+
+//loadImport finds file imported by file from and parses it once, it
+//returns absolute path of the file
+func (l *Loader) loadImport(name string, from string) (string, *loadedFile, error) {
+	filename, err := l.resolve(name, from)
+	if err != nil {
+		return "", nil, err
+	}
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return "", nil, err
+	}
+	_, seen := l.files[path]
+	file, err := l.load(filename)
+	if err != nil {
+		return "", nil, err
+	}
+	if !seen {
+		l.imports = append(l.imports, file.prog)
+	}
+	return path, file, nil
+}
+
+//resolve finds imported file
+func (l *Loader) resolve(name string, from string) (string, error) {
+	var candidates []string
+	if filepath.IsAbs(name) {
+		candidates = append(candidates, name)
+	} else {
+		candidates = append(candidates, filepath.Join(filepath.Dir(from), name))
+		for _, dir := range l.searchDirs {
+			candidates = append(candidates, filepath.Join(dir, name))
+		}
+	}
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("imported file %q not found", name)
+}
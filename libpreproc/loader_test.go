@@ -0,0 +1,157 @@
+package libpreproc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//writeFiles creates files in a new temporary directory
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "preproc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+//processModule loads main.s of files with imports and preprocesses it
+func processModule(t *testing.T, files map[string]string) (Program, error) {
+	t.Helper()
+	dir := writeFiles(t, files)
+	defer os.RemoveAll(dir)
+	m, err := NewLoader(nil, nil).LoadModule(filepath.Join(dir, "main.s"))
+	if err != nil {
+		return Program{}, err
+	}
+	return NewPreprocessor().ProcessModule(m)
+}
+
+//sourceLines formats program, returns its non-empty lines with
+//whitespace collapsed
+func sourceLines(t *testing.T, prog Program) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := WriteSource(&buf, prog); err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if fields := strings.Fields(line); len(fields) != 0 {
+			lines = append(lines, strings.Join(fields, " "))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestImport(t *testing.T) {
+	const header = `#ifdef FAST
+    add a, 2
+#else
+    add a, 1
+#endif
+section .data
+table: .byte 1
+`
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{
+			"define before import",
+			map[string]string{"h.s": header, "main.s": "section .text\n#define FAST\n#import \"h.s\"\n    mov b, a\n"},
+			"section .text\nadd a, 2\nsection .data\ntable:\n.byte 1\nsection .text\nmov b, a",
+		},
+		{
+			"define after import",
+			map[string]string{"h.s": header, "main.s": "section .text\n#import \"h.s\"\n#define FAST\n"},
+			"section .text\nadd a, 1\nsection .data\ntable:\n.byte 1",
+		},
+		{
+			"false condition",
+			map[string]string{"main.s": "section .text\n#ifdef NOPE\n#import \"missing.s\"\n#endif\n    in a\n"},
+			"section .text\nin a",
+		},
+		{
+			"imported once",
+			map[string]string{"h.s": "    out 1\n", "main.s": "section .text\n#import \"h.s\"\n#import \"h.s\"\n"},
+			"section .text\nout 1",
+		},
+		{
+			"relative to importing file",
+			map[string]string{"inc/a.s": "#import \"b.s\"\n", "inc/b.s": "    out 2\n", "main.s": "section .text\n#import \"inc/a.s\"\n"},
+			"section .text\nout 2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prog, err := processModule(t, tt.files)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := sourceLines(t, prog); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{"missing", map[string]string{"main.s": "#import \"missing.s\"\n"}, `imported file "missing.s" not found`},
+		{"cycle", map[string]string{"main.s": "#import \"a.s\"\n", "a.s": "#import \"main.s\"\n"}, "import cycle: "},
+		{"macro of missing file", map[string]string{"main.s": "#import \"missing.s\"\ntwice 1\n"}, `met variable: "twice"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := processModule(t, tt.files)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportRoundTrip(t *testing.T) {
+	files := map[string]string{
+		"h.s":    "    out 1\nsection .data\ntable: .byte 1\n",
+		"main.s": "#import \"h.s\"\n    add a, 1\nsection .text\n    mov b, a\n",
+	}
+	prog, err := processModule(t, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := WriteSource(&buf, prog); err != nil {
+		t.Fatal(err)
+	}
+	reparsed, err := NewParser(&buf).ParseFile()
+	if err != nil {
+		t.Fatalf("parse of written source: %v", err)
+	}
+	want := "out 1\nadd a, 1\nsection .data\ntable:\n.byte 1\nsection .text\nmov b, a"
+	if got := sourceLines(t, prog); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if got := sourceLines(t, reparsed); got != want {
+		t.Errorf("reparsed\n%s\nwant\n%s", got, want)
+	}
+}
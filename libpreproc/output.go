@@ -0,0 +1,276 @@
+package libpreproc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//OutputFormat - format of image file
+type OutputFormat int
+
+const (
+	//FormatBinary - raw binary starting from the lowest address
+	FormatBinary OutputFormat = iota
+	//FormatIntelHex - Intel HEX records
+	FormatIntelHex
+	//FormatSRecord - Motorola S-records
+	FormatSRecord
+	//FormatLogisim - Logisim "v2.0 raw" memory image
+	FormatLogisim
+	//FormatVerilog - Verilog $readmemh file
+	FormatVerilog
+)
+
+//recordSize - number of data bytes in one Intel HEX or S-record line
+const recordSize = 16
+
+var formatNames = map[string]OutputFormat{
+	"bin":     FormatBinary,
+	"ihex":    FormatIntelHex,
+	"srec":    FormatSRecord,
+	"logisim": FormatLogisim,
+	"memh":    FormatVerilog,
+}
+
+var formatExts = map[string]OutputFormat{
+	".bin":     FormatBinary,
+	".rom":     FormatBinary,
+	".hex":     FormatIntelHex,
+	".ihex":    FormatIntelHex,
+	".srec":    FormatSRecord,
+	".s19":     FormatSRecord,
+	".s28":     FormatSRecord,
+	".mot":     FormatSRecord,
+	".logisim": FormatLogisim,
+	".lgs":     FormatLogisim,
+	".mem":     FormatVerilog,
+	".memh":    FormatVerilog,
+	".vmem":    FormatVerilog,
+}
+
+//ParseOutputFormat returns format by its name: bin, ihex, srec, logisim or memh
+func ParseOutputFormat(name string) (OutputFormat, error) {
+	format, ok := formatNames[strings.ToLower(name)]
+	if !ok {
+		return FormatBinary, fmt.Errorf("unknown output format %q", name)
+	}
+	return format, nil
+}
+
+//FormatFromExt returns format by extension of file name
+func FormatFromExt(filename string) (OutputFormat, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	format, ok := formatExts[ext]
+	if !ok {
+		return FormatBinary, fmt.Errorf("cannot detect output format of %q", filename)
+	}
+	return format, nil
+}
+
+func (f OutputFormat) String() string {
+	for name, format := range formatNames {
+		if format == f {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+//Memory returns contents of all segments as one block starting from
+//the lowest segment origin, gaps between segments are filled with zeros
+func (img Image) Memory() (int, []byte) {
+	if len(img.segments) == 0 {
+		return 0, nil
+	}
+	start, end := img.segments[0].origin, 0
+	for _, seg := range img.segments {
+		if seg.origin < start {
+			start = seg.origin
+		}
+		if last := seg.origin + len(seg.data); last > end {
+			end = last
+		}
+	}
+	mem := make([]byte, end-start)
+	for _, seg := range img.segments {
+		copy(mem[seg.origin-start:], seg.data)
+	}
+	return start, mem
+}
+
+//WriteImageFile writes image into file in specified format
+func WriteImageFile(filename string, img Image, format OutputFormat) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := WriteImage(f, img, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+//WriteOutput writes image into OUTPUT file of linker script,
+//format is detected by file extension
+func (l *LinkerScript) WriteOutput(img Image) error {
+	if l == nil || l.OUTPUT == "" {
+		return fmt.Errorf("no OUTPUT in linker script")
+	}
+	format, err := FormatFromExt(l.OUTPUT)
+	if err != nil {
+		return err
+	}
+	return WriteImageFile(l.OUTPUT, img, format)
+}
+
+//WriteImage writes image in specified format
+func WriteImage(w io.Writer, img Image, format OutputFormat) error {
+	bw := bufio.NewWriter(w)
+	var err error
+	switch format {
+	case FormatBinary:
+		_, mem := img.Memory()
+		_, err = bw.Write(mem)
+	case FormatIntelHex:
+		err = writeIntelHex(bw, img)
+	case FormatSRecord:
+		err = writeSRecord(bw, img)
+	case FormatLogisim:
+		err = writeLogisim(bw, img)
+	case FormatVerilog:
+		err = writeVerilog(bw, img)
+	default:
+		err = fmt.Errorf("unknown output format %d", format)
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+//writeIntelHex writes data records with extended linear address records
+//for addresses above 64K and the end of file record
+func writeIntelHex(w *bufio.Writer, img Image) error {
+	upper := 0
+	for _, seg := range img.segments {
+		for off := 0; off < len(seg.data); {
+			addr := seg.origin + off
+			if addr>>16 != upper {
+				upper = addr >> 16
+				writeHexRecord(w, 0, 0x04, []byte{byte(upper >> 8), byte(upper)})
+			}
+			end := off + recordSize
+			//records do not cross 64K boundary
+			if limit := off + 0x10000 - addr&0xFFFF; end > limit {
+				end = limit
+			}
+			if end > len(seg.data) {
+				end = len(seg.data)
+			}
+			writeHexRecord(w, addr&0xFFFF, 0x00, seg.data[off:end])
+			off = end
+		}
+	}
+	writeHexRecord(w, 0, 0x01, nil)
+	return nil
+}
+
+func writeHexRecord(w *bufio.Writer, addr int, kind byte, data []byte) {
+	sum := byte(len(data)) + byte(addr>>8) + byte(addr) + kind
+	fmt.Fprintf(w, ":%02X%04X%02X", len(data), addr, kind)
+	for _, d := range data {
+		fmt.Fprintf(w, "%02X", d)
+		sum += d
+	}
+	fmt.Fprintf(w, "%02X\n", -sum)
+}
+
+//writeSRecord writes S0 header, S1 or S2 data records and termination
+//record, 24-bit records are used when image does not fit into 64K
+func writeSRecord(w *bufio.Writer, img Image) error {
+	start, mem := img.Memory()
+	addrLen, dataType, endType := 2, '1', '9'
+	if start+len(mem) > 0x10000 {
+		addrLen, dataType, endType = 3, '2', '8'
+	}
+	writeSRecordLine(w, '0', 2, 0, []byte("td4"))
+	for _, seg := range img.segments {
+		for off := 0; off < len(seg.data); off += recordSize {
+			end := off + recordSize
+			if end > len(seg.data) {
+				end = len(seg.data)
+			}
+			writeSRecordLine(w, dataType, addrLen, seg.origin+off, seg.data[off:end])
+		}
+	}
+	entry, _ := img.Entry()
+	writeSRecordLine(w, endType, addrLen, entry, nil)
+	return nil
+}
+
+func writeSRecordLine(w *bufio.Writer, kind rune, addrLen int, addr int, data []byte) {
+	count := byte(addrLen + len(data) + 1)
+	sum := count
+	fmt.Fprintf(w, "S%c%02X", kind, count)
+	for i := addrLen - 1; i >= 0; i-- {
+		b := byte(addr >> (8 * uint(i)))
+		fmt.Fprintf(w, "%02X", b)
+		sum += b
+	}
+	for _, d := range data {
+		fmt.Fprintf(w, "%02X", d)
+		sum += d
+	}
+	fmt.Fprintf(w, "%02X\n", ^sum)
+}
+
+//writeLogisim writes memory from address zero, runs of equal values
+//are written as count*value
+func writeLogisim(w *bufio.Writer, img Image) error {
+	start, mem := img.Memory()
+	mem = append(make([]byte, start), mem...)
+	fmt.Fprintf(w, "v2.0 raw\n")
+	col := 0
+	for i := 0; i < len(mem); {
+		run := 1
+		for i+run < len(mem) && mem[i+run] == mem[i] {
+			run++
+		}
+		if run > 3 {
+			fmt.Fprintf(w, "%d*%x", run, mem[i])
+		} else {
+			run = 1
+			fmt.Fprintf(w, "%x", mem[i])
+		}
+		i += run
+		col++
+		if col == 8 || i == len(mem) {
+			fmt.Fprintf(w, "\n")
+			col = 0
+		} else {
+			fmt.Fprintf(w, " ")
+		}
+	}
+	return nil
+}
+
+//writeVerilog writes $readmemh file, every segment starts with its address
+func writeVerilog(w *bufio.Writer, img Image) error {
+	for _, seg := range img.segments {
+		fmt.Fprintf(w, "@%x\n", seg.origin)
+		for i, d := range seg.data {
+			fmt.Fprintf(w, "%02x", d)
+			if (i+1)%recordSize == 0 || i == len(seg.data)-1 {
+				fmt.Fprintf(w, "\n")
+			} else {
+				fmt.Fprintf(w, " ")
+			}
+		}
+	}
+	return nil
+}
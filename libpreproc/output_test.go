@@ -0,0 +1,78 @@
+package libpreproc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteImage(t *testing.T) {
+	img := Image{segments: []Segment{{origin: 0, data: []byte{0x02, 0x73, 0, 0, 0, 0, 0xF6}}}}
+	tests := []struct {
+		format OutputFormat
+		want   string
+	}{
+		{FormatIntelHex, ":07000000027300000000F68E\n:00000001FF\n"},
+		{FormatLogisim, "v2.0 raw\n2 73 4*0 f6\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := WriteImage(&buf, img, tt.format); err != nil {
+			t.Fatalf("%s: %v", tt.format, err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.format, buf.String(), tt.want)
+		}
+	}
+}
+
+func TestImageRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    OutputFormat
+		segments  []Segment
+		wantStart int
+		want      []byte
+	}{
+		{"binary", FormatBinary, []Segment{{origin: 0, data: []byte{1, 2, 3}}}, 0, []byte{1, 2, 3}},
+		{"binary gap", FormatBinary, []Segment{{origin: 0, data: []byte{1}}, {origin: 3, data: []byte{4}}}, 0, []byte{1, 0, 0, 4}},
+		{"ihex", FormatIntelHex, []Segment{{origin: 2, data: []byte{0xE2, 0xF0}}}, 2, []byte{0xE2, 0xF0}},
+		{"ihex gap", FormatIntelHex, []Segment{{origin: 0, data: []byte{1}}, {origin: 3, data: []byte{4}}}, 0, []byte{1, 0, 0, 4}},
+		{"ihex extended address", FormatIntelHex, []Segment{{origin: 0x1FFFF, data: []byte{5, 6}}}, 0x1FFFF, []byte{5, 6}},
+		{"logisim", FormatLogisim, []Segment{{origin: 0, data: bytes.Repeat([]byte{7}, 20)}}, 0, bytes.Repeat([]byte{7}, 20)},
+		{"logisim origin", FormatLogisim, []Segment{{origin: 2, data: []byte{0xAB}}}, 0, []byte{0, 0, 0xAB}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteImage(&buf, Image{segments: tt.segments}, tt.format); err != nil {
+				t.Fatal(err)
+			}
+			start, mem, err := ReadImage(&buf, tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if start != tt.wantStart || !bytes.Equal(mem, tt.want) {
+				t.Errorf("got %d %v, want %d %v", start, mem, tt.wantStart, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadImageErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format OutputFormat
+		src    string
+	}{
+		{"ihex checksum", FormatIntelHex, ":0100000001FF\n:00000001FF\n"},
+		{"ihex no colon", FormatIntelHex, "0100000001FE\n"},
+		{"logisim header", FormatLogisim, "1 2 3\n"},
+		{"srec", FormatSRecord, ""},
+	}
+	for _, tt := range tests {
+		if _, _, err := ReadImage(strings.NewReader(tt.src), tt.format); err == nil {
+			t.Errorf("%s: error expected", tt.name)
+		}
+	}
+}
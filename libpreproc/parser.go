@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
 )
 
 //Module represents program with all its imports
@@ -12,16 +13,25 @@ type Module struct {
 	labelList []string
 	main      Program
 	imports   []Program
+	path      string  //absolute path of main file
+	loader    *Loader //evaluates #import during preprocessing
 }
 
 //Parser represents a parser
 type Parser struct {
 	macroList []string
 	labelList []string
+	loader    *Loader
+	filename  string    //file being parsed, #line doesn't change it
+	inMacro   bool      //body of #macro is being parsed
+	stmtPos   Pos       //position of the statement being parsed
+	codeLine  int       //line of the last scanned code token
+	comments  []Comment //comments met inside statements
 	s         *Scanner
 	buf       struct {
 		tok Token  //last read token
 		lit string //last read literal
+		pos Pos    //last read position
 		n   int    //buffer size (max = 1)
 	}
 }
@@ -31,9 +41,25 @@ func NewParser(r io.Reader) *Parser {
 	return &Parser{s: NewScanner(r)}
 }
 
+//NewFileParser returns a new instance of Parser reporting positions in file
+func NewFileParser(r io.Reader, filename string) *Parser {
+	return &Parser{s: NewFileScanner(r, filename), filename: filename}
+}
+
 // scan returns the next token from the underlying scanner.
 // If a token has been unscanned then read that instead.
+// Comments are skipped and kept until the end of statement
 func (p *Parser) scan() (tok Token, lit string) {
+	tok, lit = p.scanComment()
+	for tok == COMMENT {
+		p.comments = append(p.comments, p.newComment(lit))
+		tok, lit = p.scanComment()
+	}
+	return tok, lit
+}
+
+//scanComment returns the next token like scan, but comments are returned too
+func (p *Parser) scanComment() (tok Token, lit string) {
 	// If we have a token on the buffer, then return it
 	if p.buf.n != 0 {
 		p.buf.n = 0
@@ -41,13 +67,37 @@ func (p *Parser) scan() (tok Token, lit string) {
 	}
 
 	//Otherwise read the next token from the scanner
-	tok, lit = p.s.Scan()
+	tok, lit, pos := p.s.Scan()
 
 	//Save it to the buffer in case we unscan later
-	p.buf.tok, p.buf.lit = tok, lit
+	p.buf.tok, p.buf.lit, p.buf.pos = tok, lit, pos
+	if tok != WS && tok != COMMENT && tok != EOF {
+		p.codeLine = pos.Line
+	}
 	return
 }
 
+//newComment returns comment read by the last scan
+func (p *Parser) newComment(text string) Comment {
+	pos := p.lastPos()
+	return Comment{text: text, trailing: pos.Line == p.codeLine, pos: pos}
+}
+
+//takeComments returns comments met inside statements and clears the list
+func (p *Parser) takeComments() []Stmt {
+	var stmts []Stmt
+	for _, comment := range p.comments {
+		stmts = append(stmts, comment)
+	}
+	p.comments = nil
+	return stmts
+}
+
+//lastPos returns position of the last read token
+func (p *Parser) lastPos() Pos {
+	return p.buf.pos
+}
+
 //unscan pushes the prev read token back to buffer
 func (p *Parser) unscan() {
 	p.buf.n = 1
@@ -56,67 +106,129 @@ func (p *Parser) unscan() {
 // scanIgnoreWhitespace scans the next non-whitespace token.
 func (p *Parser) scanIgnoreWhitespace() (tok Token, lit string) {
 	tok, lit = p.scan()
-	if tok == WS {
+	for tok == WS {
 		tok, lit = p.scan()
 	}
 	return tok, lit
 }
 
-func (p *Parser) getStringValue() string {
-	tok, lit := p.scan()
-	var str string
-	for tok != QUOTE {
-		str = str + lit
-		tok, lit = p.scan()
+func (p *Parser) getStringValue() (string, error) {
+	pos := p.lastPos()
+	str, ok := p.s.scanString()
+	if !ok {
+		return str, errorAt(pos, "unterminated string")
 	}
-	return str
+	return str, nil
 }
 
-//ParseFile parses the whole file
+//ParseFile parses the whole file. Parsing continues after errors, so
+//the returned program is partial if error is ErrorList of all errors found
 func (p *Parser) ParseFile() (Program, error) {
 	var prog Program
-	var er error
+	var errs ErrorList
 	for {
 		tok, lit := p.scanIgnoreWhitespace()
 		if tok == EOF {
 			break
 		}
 		var section Section
+		section.pos = p.lastPos()
+		if tok == SECTION && len(p.comments) != 0 {
+			//comments before the first section belong to unnamed section
+			p.unscan()
+			section.sectionContent.elements = p.takeComments()
+			prog.sections = append(prog.sections, section)
+			continue
+		}
 		if tok == SECTION {
 			tok, lit = p.scanIgnoreWhitespace()
 			if tok != IDENT {
-				return prog, fmt.Errorf("found %q, expected section name", lit)
+				errs.Add(errorAt(p.lastPos(), "found %q, expected section name", lit))
+				p.skipStmt(section.pos.Line)
 			}
 			section.sectionName = lit
-			section.sectionContent, er = p.ParseBlock()
-			if er != nil {
-				return prog, er
-			}
-			prog.sections = append(prog.sections, section)
+		} else {
+			//statements before the first section belong to unnamed section
+			p.unscan()
+		}
+		content, err := p.ParseBlock()
+		errs.Add(err)
+		section.sectionContent = content
+		prog.sections = append(prog.sections, section)
+		tok, lit = p.scanIgnoreWhitespace()
+		if tok != SECTION && tok != EOF {
+			errs.Add(errorAt(p.lastPos(), "unexpected %q", lit))
+			continue
 		}
+		p.unscan()
 	}
-	return prog, nil
+	return prog, errs.Err()
 }
 
-//ParseBlock parses one section
+//ParseBlock parses statements up to the end of block. Statement with
+//error is skipped up to the next line or directive and parsing goes on
 func (p *Parser) ParseBlock() (Block, error) {
 	var block Block
+	var errs ErrorList
+	block.elements = p.takeComments()
 	for {
 		stmt, err := p.Parse()
-		if stmt == EOF || stmt == ENDIF || stmt == EOS || stmt == ELSE {
+		if stmt == EOF || stmt == ENDIF || stmt == EOS || stmt == ELSE || stmt == ELIF || stmt == ENDMACRO || stmt == ENDFOR ||
+			stmt == ENDREPT || stmt == ENDWHILE {
+			block.elements = append(block.elements, p.takeComments()...)
 			break
 		}
 		if err != nil {
-			return block, err
+			errs.Add(err)
+			if stmt == nil {
+				p.skipStmt(p.stmtPos.Line)
+			}
+		}
+		if stmt != nil {
+			block.elements = append(block.elements, stmt)
+		}
+		block.elements = append(block.elements, p.takeComments()...)
+	}
+	return block, errs.Err()
+}
+
+//skipStmt skips tokens up to the end of line or beginning of statement
+//at one of the following lines
+func (p *Parser) skipStmt(line int) {
+	tok, lit := p.buf.tok, p.buf.lit
+	if p.buf.n != 0 {
+		tok, lit = p.scan()
+	}
+	for {
+		switch {
+		case tok == EOF:
+			p.unscan()
+			return
+		case tok == WS:
+			if hasNewLine(lit) {
+				return
+			}
+		case p.lastPos().Line > line && startsStmt(tok):
+			p.unscan()
+			return
 		}
-		block.elements = append(block.elements, stmt)
+		tok, lit = p.scan()
 	}
-	return block, nil
 }
 
-//Parse parses all keywords and calls their handlers
+//startsStmt reports whether token begins a statement
+func startsStmt(tok Token) bool {
+	return tok == SECTION || (tok >= IMPORT && tok <= JNC)
+}
+
+//Parse parses all keywords and calls their handlers. If parsing fails
+//but statement is complete, it is returned together with the error
 func (p *Parser) Parse() (Stmt, error) {
-	tok, _ := p.scanIgnoreWhitespace()
+	tok, lit := p.scanComment()
+	if tok == WS {
+		tok, lit = p.scanComment()
+	}
+	p.stmtPos = p.lastPos()
 	var stmt Stmt
 	var er error
 	switch tok {
@@ -127,8 +239,10 @@ func (p *Parser) Parse() (Stmt, error) {
 		stmt, er = p.ParseDefine()
 	case IMPORT:
 		stmt, er = p.ParseImport()
-	// case LINE:
-	// 	stmt, er = p.ParseLine()
+	case LINE:
+		stmt, er = p.ParseLine()
+	case PRAGMA:
+		stmt, er = p.ParsePragma()
 	case WARN:
 		stmt, er = p.ParseWarn()
 	case SUMDEF:
@@ -148,10 +262,16 @@ func (p *Parser) Parse() (Stmt, error) {
 	case EOF:
 		stmt = EOF
 		er = nil
+	case IF:
+		stmt, er = p.ParseIf(false)
 	case ELSE:
 		p.unscan()
 		stmt = ELSE
 		er = nil
+	case ELIF:
+		p.unscan()
+		stmt = ELIF
+		er = nil
 	case ENDIF:
 		p.unscan()
 		stmt = ENDIF
@@ -161,8 +281,31 @@ func (p *Parser) Parse() (Stmt, error) {
 	case MACRO:
 		stmt, er = p.ParseMacro()
 	case ENDMACRO:
+		p.unscan()
 		stmt = ENDMACRO
 		er = nil
+	case FOR:
+		stmt, er = p.ParseFor()
+	case ENDFOR:
+		p.unscan()
+		stmt = ENDFOR
+		er = nil
+	case REPT:
+		stmt, er = p.ParseRept()
+	case ENDREPT:
+		p.unscan()
+		stmt = ENDREPT
+		er = nil
+	case WHILE:
+		stmt, er = p.ParseWhile()
+	case ENDWHILE:
+		p.unscan()
+		stmt = ENDWHILE
+		er = nil
+	case NIBBLE, BYTE, STRING:
+		stmt, er = p.ParseData(tok)
+	case SPACE:
+		stmt, er = p.ParseSpace()
 	case ADD:
 		stmt, er = p.ParseAdd()
 	case MOV:
@@ -177,68 +320,143 @@ func (p *Parser) Parse() (Stmt, error) {
 		stmt, er = p.ParseJmp()
 	case JNC:
 		stmt, er = p.ParseJnc()
-	case IDENT:
+	case IDENT, QUOTE, LOC, LPAREN, PLUS, MINUS, TILDE, NOT:
 		p.unscan()
-		stmt, er = p.ParseIdent()
-	case LOC:
-		stmt = LOC
+		return p.identStmt()
+	case COMMENT:
+		stmt = p.newComment(lit)
 		er = nil
-	}
-	switch stmt.(type) {
-	case *Variable:
-		varVal := stmt.(*Variable).name
-		return nil, fmt.Errorf("preprocessor directive expected, met variable: %q", varVal)
+	case ILLEGAL:
+		if strings.HasPrefix(lit, "/*") {
+			return nil, errorAt(p.stmtPos, "unterminated comment")
+		}
+		return nil, errorAt(p.stmtPos, "unexpected %q", lit)
+	default:
+		return nil, errorAt(p.stmtPos, "unexpected %q", lit)
 	}
 	return stmt, er
 }
 
+//identStmt parses statement starting with identifier or value:
+//label, macro call or data
+func (p *Parser) identStmt() (Stmt, error) {
+	id, err := p.ParseIdent()
+	switch v := id.(type) {
+	case nil:
+		return nil, err
+	case Variable:
+		return nil, errorAt(p.stmtPos, "preprocessor directive expected, met variable: %q", v.name)
+	case Stmt:
+		return v, err
+	}
+	return nil, errorAt(p.stmtPos, "unexpected expression %q", identString(id))
+}
+
 //ParseDefine - #define
 func (p *Parser) ParseDefine() (Stmt, error) {
-	name, err := p.ParseIdent()
+	pos := p.lastPos()
+	name, err := p.parseName()
 	if err != nil {
 		return nil, err
 	}
+	if p.lineEnd() {
+		return Define{name: name, definition: nil, pos: pos}, nil
+	}
 	definition, err := p.ParseIdent()
 	if err != nil {
 		return nil, err
 	}
-	return Define{name: name, definition: definition}, nil
+	return Define{name: name, definition: definition, pos: pos}, nil
 }
 
 //ParseImport - #import
 func (p *Parser) ParseImport() (Stmt, error) {
+	pos := p.lastPos()
 	name, err := p.ParseIdent()
 	if err != nil {
 		return nil, err
 	}
-	return Import{name: name}, nil
+	if p.loader != nil {
+		p.loader.importFile(p, messageText(name), p.filename)
+	}
+	return Import{name: name, pos: pos}, nil
 }
 
-// //ParseLine - #line
-// func (p *Parser) ParseLine() (Stmt, error) {
-// 	name, err := p.ParseIdent()
-// 	if err != nil {
-// 		return nil, err
-// 	}
-// 	lineNumber, err := p.ParseIdent()
-// 	if err != nil {
-// 		return nil, err
-// 	}
-// 	return Line{name: name, lineNumber: lineNumber}, nil
-// }
+//ParseLine - #line. Line following the directive gets the given number,
+//positions of the rest of file are renumbered accordingly
+func (p *Parser) ParseLine() (Stmt, error) {
+	pos := p.lastPos()
+	lineNumber, err := p.ParseIdent()
+	if err != nil {
+		return nil, err
+	}
+	number, err := NumberValue(lineNumber)
+	if err != nil {
+		return nil, errorAt(posOf(lineNumber), "line number expected, met %q", identString(lineNumber))
+	}
+	if number < 1 {
+		return nil, errorAt(posOf(lineNumber), "invalid line number %d", number)
+	}
+	var name Ident
+	if !p.lineEnd() {
+		//file name is either quoted or a bare word like asm.s
+		if tok, _ := p.scanIgnoreWhitespace(); tok == QUOTE {
+			p.unscan()
+			name, err = p.ParseIdent()
+		} else {
+			p.unscan()
+			name, err = p.parseName()
+		}
+		if err != nil {
+			return nil, err
+		}
+		//trailing comment keeps position of the directive
+		if !p.lineEnd() {
+			_, lit := p.scanIgnoreWhitespace()
+			return nil, errorAt(p.lastPos(), "unexpected %q after #line", lit)
+		}
+	}
+	p.s.renumber(number-(pos.Line+1), messageText(name))
+	return Line{name: name, lineNumber: lineNumber, pos: pos}, nil
+}
+
+//ParsePragma - #pragma, the rest of line is split into words
+func (p *Parser) ParsePragma() (Stmt, error) {
+	pos := p.lastPos()
+	var text strings.Builder
+	for {
+		tok, lit := p.scan()
+		if tok == EOF || (tok == WS && hasNewLine(lit)) {
+			p.unscan()
+			break
+		}
+		text.WriteString(lit)
+	}
+	words := strings.Fields(text.String())
+	if len(words) == 0 {
+		return nil, errorAt(pos, "pragma name expected")
+	}
+	var args []string
+	if len(words) > 1 {
+		args = words[1:]
+	}
+	return Pragma{name: words[0], args: args, pos: pos}, nil
+}
 
 //ParseWarn - #warn
 func (p *Parser) ParseWarn() (Stmt, error) {
+	pos := p.lastPos()
 	message, err := p.ParseIdent()
 	if err != nil {
 		return nil, err
 	}
-	return Warn{message: message}, nil
+	return Warn{message: message, pos: pos}, nil
 }
 
 //ParseSumDef - #sumdef
 func (p *Parser) ParseSumDef() (Stmt, error) {
-	def1, err := p.ParseIdent()
+	pos := p.lastPos()
+	def1, err := p.parseName()
 	if err != nil {
 		return nil, err
 	}
@@ -246,12 +464,13 @@ func (p *Parser) ParseSumDef() (Stmt, error) {
 	if err != nil {
 		return nil, err
 	}
-	return Sumdef{def1: def1, def2: def2}, nil
+	return Sumdef{def1: def1, def2: def2, pos: pos}, nil
 }
 
 //ParseResDef - #resdef
 func (p *Parser) ParseResDef() (Stmt, error) {
-	def1, err := p.ParseIdent()
+	pos := p.lastPos()
+	def1, err := p.parseName()
 	if err != nil {
 		return nil, err
 	}
@@ -259,12 +478,13 @@ func (p *Parser) ParseResDef() (Stmt, error) {
 	if err != nil {
 		return nil, err
 	}
-	return Resdef{def1: def1, def2: def2}, nil
+	return Resdef{def1: def1, def2: def2, pos: pos}, nil
 }
 
 //ParsePext - #pext
 func (p *Parser) ParsePext() (Stmt, error) {
-	pextName, err := p.ParseIdent()
+	pos := p.lastPos()
+	pextName, err := p.parseName()
 	if err != nil {
 		return nil, err
 	}
@@ -272,152 +492,566 @@ func (p *Parser) ParsePext() (Stmt, error) {
 	if err != nil {
 		return nil, err
 	}
-	return Pext{pextName: pextName, pextAddress: pextAddress}, nil
+	return Pext{pextName: pextName, pextAddress: pextAddress, pos: pos}, nil
 }
 
 //ParseError - #error
 func (p *Parser) ParseError() (Stmt, error) {
+	pos := p.lastPos()
 	message, err := p.ParseIdent()
 	if err != nil {
 		return nil, err
 	}
-	return Error{message: message}, nil
+	return Error{message: message, pos: pos}, nil
 }
 
 //ParseUndef - #undef
 func (p *Parser) ParseUndef() (Stmt, error) {
-	definition, err := p.ParseIdent()
+	pos := p.lastPos()
+	definition, err := p.parseName()
 	if err != nil {
 		return nil, err
 	}
-	return Undef{definition: definition}, nil
+	return Undef{definition: definition, pos: pos}, nil
 }
 
 //ParseIfdef - #ifdef
 func (p *Parser) ParseIfdef() (Stmt, error) {
-	definition, err := p.ParseIdent()
+	pos := p.lastPos()
+	var errs ErrorList
+	definition, err := p.parseName()
 	if err != nil {
-		return nil, err
-	}
-	bodyTrue, err := p.ParseBlock()
-	tok, _ := p.scanIgnoreWhitespace()
-	var bodyFalse Block
-	if tok == ELSE {
-		bodyFalse, err = p.ParseBlock()
-		if err != nil {
-			return nil, err
-		}
-		_, _ = p.scanIgnoreWhitespace()
-	} else {
-		if tok != ENDIF {
-			p.unscan()
-		}
+		errs.Add(err)
+		p.skipStmt(pos.Line)
 	}
-	return Ifdef{definition: definition, bodyTrue: bodyTrue, bodyFalse: bodyFalse}, nil
+	bodyTrue, bodyFalse, err := p.parseBranches()
+	errs.Add(err)
+	return Ifdef{definition: definition, bodyTrue: bodyTrue, bodyFalse: bodyFalse, pos: pos}, errs.Err()
 }
 
 //ParseIfndef - #ifdef
 func (p *Parser) ParseIfndef() (Stmt, error) {
-	definition, err := p.ParseIdent()
+	pos := p.lastPos()
+	var errs ErrorList
+	definition, err := p.parseName()
 	if err != nil {
-		return nil, err
+		errs.Add(err)
+		p.skipStmt(pos.Line)
+	}
+	bodyTrue, bodyFalse, err := p.parseBranches()
+	errs.Add(err)
+	return Ifndef{definition: definition, bodyTrue: bodyTrue, bodyFalse: bodyFalse, pos: pos}, errs.Err()
+}
+
+//ParseIf - #if and #elif
+func (p *Parser) ParseIf(elif bool) (Stmt, error) {
+	pos := p.lastPos()
+	var errs ErrorList
+	condition, err := p.ParseIdent()
+	if err != nil {
+		errs.Add(err)
+		p.skipStmt(pos.Line)
 	}
+	bodyTrue, bodyFalse, err := p.parseBranches()
+	errs.Add(err)
+	return If{condition: condition, bodyTrue: bodyTrue, bodyFalse: bodyFalse, elif: elif, pos: pos}, errs.Err()
+}
+
+//parseBranches parses conditional bodies up to and including #endif,
+//#elif branch is parsed as If being the only statement of false body
+func (p *Parser) parseBranches() (Block, Block, error) {
+	var errs ErrorList
+	var bodyFalse Block
 	bodyTrue, err := p.ParseBlock()
+	errs.Add(err)
 	tok, _ := p.scanIgnoreWhitespace()
-	var bodyFalse Block
+	if tok == ELIF {
+		elif, err := p.ParseIf(true)
+		errs.Add(err)
+		bodyFalse.elements = append(bodyFalse.elements, elif)
+		return bodyTrue, bodyFalse, errs.Err()
+	}
 	if tok == ELSE {
 		bodyFalse, err = p.ParseBlock()
-		if err != nil {
-			return nil, err
-		}
-	} else {
+		errs.Add(err)
+		tok, _ = p.scanIgnoreWhitespace()
+	}
+	if tok != ENDIF {
 		p.unscan()
+		errs.Add(errorAt(p.lastPos(), "#endif expected"))
 	}
-	return Ifndef{definition: definition, bodyTrue: bodyTrue, bodyFalse: bodyFalse}, nil
+	return bodyTrue, bodyFalse, errs.Err()
 }
 
 //ParseReturn - #return
 func (p *Parser) ParseReturn() (Stmt, error) {
+	pos := p.lastPos()
+	if p.lineEnd() {
+		return Return{pos: pos}, nil
+	}
 	returnName, err := p.ParseIdent()
 	if err != nil {
 		return nil, err
 	}
-	return Return{returnValue: returnName}, nil
+	return Return{returnValue: returnName, pos: pos}, nil
 }
 
 //ParseIdent - parses any unknown words: variables, strings, numbers,
-//labels and macro calls
+//labels and macro calls, and constant expressions of them
 func (p *Parser) ParseIdent() (Ident, error) {
 	tok, ident := p.scanIgnoreWhitespace()
-	switch tok {
-	case IDENT: //macrocall, variable, number
-		//test for number
-		isNum, num := numberIdent(ident)
-		if isNum {
-			return Number{value: num}, nil
-		}
-		tok, _ := p.scanIgnoreWhitespace()
-		switch tok {
-		//Test if it's a label: next token should be COLON
-		case COLON:
-			p.labelList = append(p.labelList, ident)
-			colErr := p.checkLabelMacroCollision(ident)
-			if colErr != nil {
-				return nil, colErr
-			}
-			return Label{name: Variable{name: ident}}, nil
-		default:
+	pos := p.lastPos()
+	if isNum, _ := numberIdent(ident); tok != IDENT || isNum {
+		p.unscan()
+		return p.parseExpr(lowestPrec + 1)
+	}
+	tok, _ = p.scan()
+	//Test if it's a label: next token should be COLON
+	if tok == COLON {
+		if err := p.checkLocal(ident, pos); err != nil {
+			return nil, err
+		}
+		p.labelList = append(p.labelList, ident)
+		colErr := p.checkLabelMacroCollision(ident)
+		if colErr != nil {
+			return nil, withPos(pos, colErr)
+		}
+		return Label{name: Variable{name: ident, pos: pos}, pos: pos}, nil
+	}
+	p.unscan()
+	//macro name directly followed by ( is a call expression
+	if _, foundMacro := find(p.macroList, ident); foundMacro && tok != LPAREN {
+		call, err := p.ParseMacroCall(ident, pos)
+		if err != nil {
+			return nil, err
+		}
+		return call.(MacroCall), nil
+	}
+	x, err := p.identOperand(ident, pos)
+	if err != nil {
+		return nil, err
+	}
+	return p.parseBinary(x, lowestPrec+1)
+}
+
+//parseExpr parses expression with binary operators of precedence prec
+//and higher
+func (p *Parser) parseExpr(prec int) (Ident, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return p.parseBinary(x, prec)
+}
+
+//parseBinary parses binary operators of precedence prec and higher
+//following the left operand x on the same line
+func (p *Parser) parseBinary(x Ident, prec int) (Ident, error) {
+	for {
+		op := p.scanOperator()
+		opPrec := op.Precedence()
+		if opPrec < prec {
 			p.unscan()
-			_, foundMacro := find(p.macroList, ident)
-			if foundMacro {
-				return p.ParseMacroCall(ident)
-			}
-			label, foundLabel := find(p.labelList, ident)
-			if foundLabel {
-				return Label{name: Variable{name: p.labelList[label]}}, nil
+			return x, nil
+		}
+		y, err := p.parseExpr(opPrec + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = Binary{op: op, x: x, y: y, pos: posOf(x)}
+	}
+}
+
+//scanOperator scans the next token on the current line, newline is
+//returned as WS token
+func (p *Parser) scanOperator() Token {
+	tok, lit := p.scan()
+	for tok == WS && !hasNewLine(lit) {
+		tok, lit = p.scan()
+	}
+	return tok
+}
+
+//parseUnary parses operand with optional unary operators,
+//signed numbers are folded into Number
+func (p *Parser) parseUnary() (Ident, error) {
+	tok, lit := p.scanIgnoreWhitespace()
+	switch tok {
+	case PLUS, MINUS, TILDE, NOT:
+		pos := p.lastPos()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if num, ok := x.(Number); ok && (tok == PLUS || tok == MINUS) {
+			if tok == MINUS {
+				num.value = -num.value
+				num.lit = "-" + num.lit
 			}
-			return Variable{name: ident}, nil
+			num.pos = pos
+			return num, nil
 		}
+		return Unary{op: tok, x: x, pos: pos}, nil
+	}
+	return p.parseOperand(tok, lit)
+}
+
+//parseOperand parses operand of expression, the first token is scanned
+func (p *Parser) parseOperand(tok Token, lit string) (Ident, error) {
+	pos := p.lastPos()
+	switch tok {
+	case IDENT:
+		if isNum, num := numberIdent(lit); isNum {
+			return Number{value: num, lit: lit, pos: pos}, nil
+		}
+		return p.identOperand(lit, pos)
 	case QUOTE: //SimpleString
-		str := p.getStringValue()
-		return SimpleString{value: str}, nil
+		str, err := p.getStringValue()
+		if err != nil {
+			return nil, err
+		}
+		return SimpleString{value: str, pos: pos}, nil
+	case LOC:
+		return Location{pos: pos}, nil
+	case LPAREN:
+		x, err := p.parseExpr(lowestPrec + 1)
+		if err != nil {
+			return nil, err
+		}
+		if tok, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+			return nil, errorAt(p.lastPos(), "expected ), met %q", lit)
+		}
+		return x, nil
 	default: //Else (???)
-		return nil, fmt.Errorf("forbidden symbol %q in context", ident)
+		return nil, errorAt(pos, "forbidden symbol %q in context", lit)
+	}
+}
+
+//identOperand returns function call, label or variable named ident
+func (p *Parser) identOperand(ident string, pos Pos) (Ident, error) {
+	tok, _ := p.scan()
+	if ident == definedFunc {
+		return p.parseDefined(tok == LPAREN, pos)
+	}
+	if tok == LPAREN {
+		return p.parseCall(ident, pos)
+	}
+	p.unscan()
+	if err := p.checkLocal(ident, pos); err != nil {
+		return nil, err
+	}
+	return p.nameOperand(ident, pos), nil
+}
+
+//checkLocal returns error if macro-local label is used outside of macro
+func (p *Parser) checkLocal(ident string, pos Pos) error {
+	if isLocalLabel(ident) && !p.inMacro {
+		return errorAt(pos, "local label %s outside of macro", ident)
+	}
+	return nil
+}
+
+//nameOperand returns label if ident is a known label, variable otherwise
+func (p *Parser) nameOperand(ident string, pos Pos) Ident {
+	if label, foundLabel := find(p.labelList, ident); foundLabel {
+		return Label{name: Variable{name: p.labelList[label], pos: pos}, pos: pos}
+	}
+	return Variable{name: ident, pos: pos}
+}
+
+//parseCall parses arguments of function call, opening parenthesis is scanned
+func (p *Parser) parseCall(function string, pos Pos) (Ident, error) {
+	_, isMacro := find(p.macroList, function)
+	if _, ok := functions[function]; !ok && !isMacro {
+		return nil, errorAt(pos, "unknown function %q", function)
+	}
+	//macros shadow built-in functions, their #return value is used
+	call := func(args []Ident) Ident {
+		if isMacro {
+			return MacroCall{macroName: function, args: args, pos: pos}
+		}
+		return Call{function: function, args: args, pos: pos}
+	}
+	var args []Ident
+	if tok, _ := p.scanIgnoreWhitespace(); tok == RPAREN {
+		return call(args), nil
+	}
+	p.unscan()
+	for {
+		arg, err := p.parseExpr(lowestPrec + 1)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		tok, lit := p.scanIgnoreWhitespace()
+		if tok == RPAREN {
+			return call(args), nil
+		}
+		if tok != COMMA {
+			return nil, errorAt(p.lastPos(), "expected , or ), met %q", lit)
+		}
+	}
+}
+
+//parseDefined parses defined(NAME) or defined NAME
+func (p *Parser) parseDefined(paren bool, pos Pos) (Ident, error) {
+	if !paren {
+		p.unscan()
+	}
+	name, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+	if paren {
+		if tok, lit := p.scanIgnoreWhitespace(); tok != RPAREN {
+			return nil, errorAt(p.lastPos(), "expected ), met %q", lit)
+		}
 	}
+	return Call{function: definedFunc, args: []Ident{name}, pos: pos}, nil
+}
+
+//parseName parses name operand of directive, e.g. #define name
+func (p *Parser) parseName() (Ident, error) {
+	tok, ident := p.scanIgnoreWhitespace()
+	pos := p.lastPos()
+	if isNum, _ := numberIdent(ident); tok != IDENT || isNum {
+		return nil, errorAt(pos, "name expected, met %q", ident)
+	}
+	return p.nameOperand(ident, pos), nil
+}
+
+//startsExpr reports whether token begins an expression
+func startsExpr(tok Token) bool {
+	switch tok {
+	case IDENT, QUOTE, LOC, LPAREN, PLUS, MINUS, TILDE, NOT:
+		return true
+	}
+	return false
 }
 
 //ParseMacro - #macro
 func (p *Parser) ParseMacro() (Stmt, error) {
+	pos := p.lastPos()
+	var errs ErrorList
 	tok, macroName := p.scanIgnoreWhitespace()
-	colErr := p.checkLabelMacroCollision(macroName)
-	if colErr != nil {
-		return nil, colErr
-	}
+	namePos := p.lastPos()
 	if tok != IDENT {
-		return nil, fmt.Errorf("macro name expected, met %q", macroName)
+		errs.Add(errorAt(p.lastPos(), "macro name expected, met %q", macroName))
 	}
-	var args []string
-	tok, arg := p.scan()
-	for {
-		if tok == IDENT {
-			args = append(args, arg)
-		} else if tok == WS {
-			if hasNewLine(arg) == true {
-				break
+	args, defaults, variadic, err := p.parseParams()
+	if err != nil {
+		errs.Add(err)
+		p.skipStmt(pos.Line)
+	}
+	//comment of header line is not a part of body expanded at every call
+	var comment string
+	if len(p.comments) != 0 && p.comments[0].trailing {
+		comment = p.comments[0].text
+		p.comments = p.comments[1:]
+	}
+	//macro is known inside its body, so it may call itself
+	p.rememberMacro(macroName)
+	if err := p.checkLabelMacroCollision(macroName); err != nil {
+		errs.Add(withPos(namePos, err))
+	}
+	inMacro := p.inMacro
+	p.inMacro = true
+	body, err := p.ParseBlock()
+	p.inMacro = inMacro
+	errs.Add(err)
+	if tok, lit := p.scanIgnoreWhitespace(); tok != ENDMACRO {
+		p.unscan()
+		errs.Add(errorAt(p.lastPos(), "#endmacro expected, met %q", lit))
+	}
+	return Macro{macroName: macroName, args: args, defaults: defaults, variadic: variadic, comment: comment, body: body, pos: pos}, errs.Err()
+}
+
+//variadicSuffix ends name of variadic parameter
+const variadicSuffix = "..."
+
+//parseParams parses macro parameters up to the end of line. Parameter
+//may have default value, name = value, the last one may be variadic,
+//name... Defaults are nil unless some parameter has one
+func (p *Parser) parseParams() (args []string, defaults []Ident, variadic bool, err error) {
+	hasDefault := false
+	for !p.lineEnd() {
+		tok, name := p.scanIgnoreWhitespace()
+		if tok == COMMA && len(args) != 0 {
+			continue
+		}
+		pos := p.lastPos()
+		if tok != IDENT {
+			return nil, nil, false, errorAt(pos, "parameter name expected, met %q", name)
+		}
+		if variadic {
+			return nil, nil, false, errorAt(pos, "parameter %s follows variadic parameter", name)
+		}
+		if isNum, _ := numberIdent(name); isNum {
+			return nil, nil, false, errorAt(pos, "parameter name expected, met %q", name)
+		}
+		if _, dup := find(args, name); dup {
+			return nil, nil, false, errorAt(pos, "duplicate parameter %s", name)
+		}
+		tok = p.scanOperator()
+		if tok == ELLIPSIS {
+			variadic = true
+			tok = p.scanOperator()
+		}
+		var value Ident
+		if tok == ASSIGN {
+			if variadic {
+				return nil, nil, false, errorAt(pos, "variadic parameter %s cannot have default value", name)
+			}
+			if value, err = p.ParseIdent(); err != nil {
+				return nil, nil, false, err
 			}
+		} else {
+			p.unscan()
 		}
-		tok, arg = p.scan()
+		if value == nil && hasDefault && !variadic {
+			return nil, nil, false, errorAt(pos, "parameter %s without default value follows one with default", name)
+		}
+		hasDefault = hasDefault || value != nil
+		args = append(args, name)
+		defaults = append(defaults, value)
+	}
+	if !hasDefault {
+		defaults = nil
+	}
+	return args, defaults, variadic, nil
+}
+
+//ParseFor - #for name in values, values are separated by commas, a..b
+//is range of numbers from a to b
+func (p *Parser) ParseFor() (Stmt, error) {
+	pos := p.lastPos()
+	var errs ErrorList
+	variable, values, err := p.parseForHeader()
+	if err != nil {
+		errs.Add(err)
+		p.skipStmt(pos.Line)
 	}
 	body, err := p.ParseBlock()
+	errs.Add(err)
+	if tok, lit := p.scanIgnoreWhitespace(); tok != ENDFOR {
+		p.unscan()
+		errs.Add(errorAt(p.lastPos(), "#endfor expected, met %q", lit))
+	}
+	return For{variable: variable, values: values, body: body, pos: pos}, errs.Err()
+}
+
+func (p *Parser) parseForHeader() (Ident, []Ident, error) {
+	variable, err := p.parseName()
+	if err != nil {
+		return nil, nil, err
+	}
+	if tok, lit := p.scanIgnoreWhitespace(); tok != IN {
+		return nil, nil, errorAt(p.lastPos(), "expected in, met %q", lit)
+	}
+	var values []Ident
+	for {
+		value, err := p.parseForValue()
+		if err != nil {
+			return nil, nil, err
+		}
+		values = append(values, value)
+		if p.lineEnd() {
+			return variable, values, nil
+		}
+		if tok, lit := p.scanIgnoreWhitespace(); tok != COMMA {
+			return nil, nil, errorAt(p.lastPos(), "expected comma, met %q", lit)
+		}
+	}
+}
+
+//parseForValue parses value or range of values of #for, range is
+//binary expression with .. operator
+func (p *Parser) parseForValue() (Ident, error) {
+	value, err := p.ParseIdent()
 	if err != nil {
 		return nil, err
 	}
-	p.rememberMacro(macroName)
-	colErr = p.checkLabelMacroCollision(macroName)
-	if colErr != nil {
-		return nil, colErr
+	if p.scanOperator() != RANGE {
+		p.unscan()
+		return value, nil
+	}
+	to, err := p.ParseIdent()
+	if err != nil {
+		return nil, err
+	}
+	return Binary{op: RANGE, x: value, y: to, pos: posOf(value)}, nil
+}
+
+//ParseRept - #rept count[, name], name is defined to number of iteration
+//starting from 0
+func (p *Parser) ParseRept() (Stmt, error) {
+	pos := p.lastPos()
+	var errs ErrorList
+	count, variable, err := p.parseReptHeader()
+	if err != nil {
+		errs.Add(err)
+		p.skipStmt(pos.Line)
+	}
+	body, err := p.ParseBlock()
+	errs.Add(err)
+	if tok, lit := p.scanIgnoreWhitespace(); tok != ENDREPT {
+		p.unscan()
+		errs.Add(errorAt(p.lastPos(), "#endrept expected, met %q", lit))
+	}
+	return Rept{count: count, variable: variable, body: body, pos: pos}, errs.Err()
+}
+
+func (p *Parser) parseReptHeader() (Ident, Ident, error) {
+	count, err := p.ParseIdent()
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.lineEnd() {
+		return count, nil, nil
+	}
+	if tok, lit := p.scanIgnoreWhitespace(); tok != COMMA {
+		return nil, nil, errorAt(p.lastPos(), "expected comma, met %q", lit)
+	}
+	variable, err := p.parseName()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !p.lineEnd() {
+		return nil, nil, errorAt(p.lastPos(), "end of line expected after #rept")
+	}
+	return count, variable, nil
+}
+
+//ParseWhile - #while
+func (p *Parser) ParseWhile() (Stmt, error) {
+	pos := p.lastPos()
+	var errs ErrorList
+	condition, err := p.ParseIdent()
+	if err != nil {
+		errs.Add(err)
+		p.skipStmt(pos.Line)
+	}
+	body, err := p.ParseBlock()
+	errs.Add(err)
+	if tok, lit := p.scanIgnoreWhitespace(); tok != ENDWHILE {
+		p.unscan()
+		errs.Add(errorAt(p.lastPos(), "#endwhile expected, met %q", lit))
+	}
+	return While{condition: condition, body: body, pos: pos}, errs.Err()
+}
+
+//lineEnd reports whether the rest of the current line is empty.
+//Consumed whitespace is not returned to the buffer.
+func (p *Parser) lineEnd() bool {
+	tok, lit := p.scan()
+	for tok == WS {
+		if hasNewLine(lit) {
+			return true
+		}
+		tok, lit = p.scan()
 	}
-	return Macro{macroName: macroName, args: args, body: body}, nil
+	p.unscan()
+	return tok == EOF
 }
 
 func hasNewLine(str string) bool {
@@ -431,29 +1065,70 @@ func hasNewLine(str string) bool {
 }
 
 //ParseMacroCall - parses any macro call
-func (p *Parser) ParseMacroCall(macroName string) (Stmt, error) {
+func (p *Parser) ParseMacroCall(macroName string, pos Pos) (Stmt, error) {
 	var args []Ident
-	tok, arg := p.scan()
+loop:
 	for {
-		if tok == IDENT {
+		tok, arg := p.scan()
+		switch {
+		case tok == EOF:
+			break loop
+		case tok == WS:
+			if hasNewLine(arg) {
+				//end of line also ends calls in arguments of outer call
+				p.unscan()
+				break loop
+			}
+		case tok == COMMA && len(args) != 0:
+		case tok == A || tok == B || tok == PC:
+			args = append(args, Variable{name: arg, pos: p.lastPos()})
+		case startsExpr(tok):
 			p.unscan()
 			stmt, er := p.ParseIdent()
 			if er != nil {
 				return nil, er
 			}
 			args = append(args, stmt)
-		} else if tok == WS {
-			if hasNewLine(arg) == true {
-				break
-			}
+		default:
+			return nil, errorAt(p.lastPos(), "macro argument expected, met %q", arg)
+		}
+	}
+	return MacroCall{macroName: macroName, args: args, pos: pos}, nil
+}
+
+//ParseData - .nibble, .byte and .string, values are separated by commas
+func (p *Parser) ParseData(kind Token) (Stmt, error) {
+	pos := p.lastPos()
+	var values []Ident
+	for {
+		value, err := p.ParseIdent()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		if p.lineEnd() {
+			break
+		}
+		if tok, lit := p.scanIgnoreWhitespace(); tok != COMMA {
+			return nil, errorAt(p.lastPos(), "expected comma, met %q", lit)
 		}
-		tok, arg = p.scan()
 	}
-	return MacroCall{macroName: macroName, args: args}, nil
+	return Data{kind: kind, values: values, pos: pos}, nil
+}
+
+//ParseSpace - .space
+func (p *Parser) ParseSpace() (Stmt, error) {
+	pos := p.lastPos()
+	size, err := p.ParseIdent()
+	if err != nil {
+		return nil, err
+	}
+	return Reserve{size: size, pos: pos}, nil
 }
 
 //ParseAdd - add
 func (p *Parser) ParseAdd() (Opcode, error) {
+	pos := p.lastPos()
 	reg, err := p.ParseReg()
 	if err != nil {
 		return nil, err
@@ -466,11 +1141,12 @@ func (p *Parser) ParseAdd() (Opcode, error) {
 	if err != nil {
 		return nil, err
 	}
-	return Add{reg: reg, value: value}, nil
+	return Add{reg: reg, value: value, pos: pos}, nil
 }
 
 //ParseMov - mov
 func (p *Parser) ParseMov() (Opcode, error) {
+	pos := p.lastPos()
 	reg1, err := p.ParseReg()
 	if err != nil {
 		return nil, err
@@ -488,36 +1164,51 @@ func (p *Parser) ParseMov() (Opcode, error) {
 		if err != nil {
 			return nil, err
 		}
-		tok, _ = p.scanIgnoreWhitespace()
-		p.unscan()
-		if tok == IDENT {
-			val, err = p.ParseIdent()
-			if err != nil {
-				return nil, err
-			}
+		val, err = p.parseFastAdd()
+		if err != nil {
+			return nil, err
 		}
-	} else if tok == IDENT {
+	} else if startsExpr(tok) {
 		val, err = p.ParseIdent()
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		return nil, fmt.Errorf("expected reg or ident, met %q", lit)
+		return nil, errorAt(p.lastPos(), "expected reg or ident, met %q", lit)
 	}
-	return Mov{reg1: reg1, reg2: reg2, fa: val}, nil
+	return Mov{reg1: reg1, reg2: reg2, fa: val, pos: pos}, nil
+}
+
+//parseFastAdd parses optional FastAdd immediate after register operand,
+//e.g. mov a, b, 1
+func (p *Parser) parseFastAdd() (Ident, error) {
+	if p.lineEnd() {
+		return nil, nil
+	}
+	tok, _ := p.scanIgnoreWhitespace()
+	if tok != COMMA {
+		p.unscan()
+	}
+	return p.ParseIdent()
 }
 
 //ParseIn - in
 func (p *Parser) ParseIn() (Opcode, error) {
+	pos := p.lastPos()
 	reg, er := p.ParseReg()
 	if er != nil {
 		return nil, er
 	}
-	return In{reg: reg}, nil
+	fa, er := p.parseFastAdd()
+	if er != nil {
+		return nil, er
+	}
+	return In{reg: reg, fa: fa, pos: pos}, nil
 }
 
 //ParseOut - out
 func (p *Parser) ParseOut() (Opcode, error) {
+	pos := p.lastPos()
 	tok, lit := p.scanIgnoreWhitespace()
 	p.unscan()
 	reg := nr
@@ -528,27 +1219,24 @@ func (p *Parser) ParseOut() (Opcode, error) {
 		if err != nil {
 			return nil, err
 		}
-		tok, _ = p.scanIgnoreWhitespace()
-		p.unscan()
-		if tok == IDENT {
-			val, err = p.ParseIdent()
-			if err != nil {
-				return nil, err
-			}
+		val, err = p.parseFastAdd()
+		if err != nil {
+			return nil, err
 		}
-	} else if tok == IDENT {
+	} else if startsExpr(tok) {
 		val, err = p.ParseIdent()
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		return nil, fmt.Errorf("expected reg a, reg b or ident, met %q", lit)
+		return nil, errorAt(p.lastPos(), "expected reg a, reg b or ident, met %q", lit)
 	}
-	return Out{reg: reg, fa: val}, nil
+	return Out{reg: reg, fa: val, pos: pos}, nil
 }
 
 //ParseCmp - cmp
 func (p *Parser) ParseCmp() (Opcode, error) {
+	pos := p.lastPos()
 	regA, err := p.ParseReg()
 	if err != nil {
 		return nil, err
@@ -566,45 +1254,50 @@ func (p *Parser) ParseCmp() (Opcode, error) {
 		p.unscan()
 	}
 	op, err := p.ParseIdent()
-	return Cmp{regA: regA, regB: regB, operation: op}, nil
+	if err != nil {
+		return nil, err
+	}
+	return Cmp{regA: regA, regB: regB, operation: op, pos: pos}, nil
 }
 
 //ParseJmp - jmp
 func (p *Parser) ParseJmp() (Opcode, error) {
+	pos := p.lastPos()
 	tok, _ := p.scanIgnoreWhitespace()
-	if tok == IDENT {
+	if startsExpr(tok) {
 		p.unscan()
 		addr, err := p.ParseIdent()
 		if err != nil {
 			return nil, err
 		}
-		return Jmp{regB: nr, addr: addr}, nil
+		return Jmp{regB: nr, addr: addr, pos: pos}, nil
 	}
 	p.unscan()
 	reg, err := p.ParseReg()
 	if err != nil {
 		return nil, err
 	}
-	return Jmp{regB: reg, addr: nil}, nil
+	return Jmp{regB: reg, addr: nil, pos: pos}, nil
 }
 
 //ParseJnc - jnc
 func (p *Parser) ParseJnc() (Opcode, error) {
+	pos := p.lastPos()
 	tok, _ := p.scanIgnoreWhitespace()
-	if tok == IDENT {
+	if startsExpr(tok) {
 		p.unscan()
 		addr, err := p.ParseIdent()
 		if err != nil {
 			return nil, err
 		}
-		return Jnc{regB: nr, addr: addr}, nil
+		return Jnc{regB: nr, addr: addr, pos: pos}, nil
 	}
 	p.unscan()
 	reg, err := p.ParseReg()
 	if err != nil {
 		return nil, err
 	}
-	return Jnc{regB: reg, addr: nil}, nil
+	return Jnc{regB: reg, addr: nil, pos: pos}, nil
 }
 
 //ParseReg - parses any register
@@ -618,7 +1311,7 @@ func (p *Parser) ParseReg() (Reg, error) {
 	case PC:
 		return pc, nil
 	default:
-		return nr, fmt.Errorf("expected register, met %q", reg)
+		return nr, errorAt(p.lastPos(), "expected register, met %q", reg)
 	}
 }
 
@@ -633,7 +1326,7 @@ func find(slice []string, val string) (int, bool) {
 
 func numberIdent(num string) (bool, int) {
 	runeRepr := []rune(num)
-	if runeRepr[0] == '0' {
+	if len(runeRepr) > 1 && runeRepr[0] == '0' {
 		switch runeRepr[1] {
 		case 'x':
 			pnum, err := strconv.ParseInt(string(runeRepr[2:]), 16, 64)
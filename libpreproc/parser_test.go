@@ -0,0 +1,214 @@
+package libpreproc
+
+import (
+	"strings"
+	"testing"
+)
+
+//processSource parses and preprocesses source
+func processSource(t *testing.T, src string) Program {
+	t.Helper()
+	prog, err := NewParser(strings.NewReader(src)).ParseFile()
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	out, err := NewPreprocessor().Process(prog)
+	if err != nil {
+		t.Fatalf("preprocess: %v", err)
+	}
+	return out
+}
+
+//preprocess parses and preprocesses source, returns statements of all
+//sections as source lines
+func preprocess(t *testing.T, src string) []string {
+	t.Helper()
+	var lines []string
+	for _, section := range processSource(t, src).sections {
+		for _, stmt := range section.sectionContent.elements {
+			if op, ok := stmt.(Opcode); ok {
+				lines = append(lines, opcodeString(op))
+			}
+		}
+	}
+	return lines
+}
+
+func TestMacroCallEndsAtLineEnd(t *testing.T) {
+	const macros = `section .text
+#macro inc x
+    add a, x
+#endmacro
+#macro twice y
+    #return y*2
+#endmacro
+`
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{"bare call", "    inc 1\n    mov b, 3\n", []string{"add a, 1", "mov b, 3"}},
+		{"nested bare call", "    inc twice 1\n    mov b, 3\n", []string{"add a, 2", "mov b, 3"}},
+		{"nested call", "    inc twice(1)\n    mov b, 3\n", []string{"add a, 2", "mov b, 3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := preprocess(t, macros+tt.src)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+//TestMacroDocExamples checks examples of Description.md
+func TestMacroDocExamples(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{"parameter", "#macro inc2 n\n    add a, n + 2\n#endmacro\n    inc2 1\n", []string{"add a, 3"}},
+		{"recursion", "#macro fact n\n#if n <= 1\n    #return 1\n#endif\n    #return n * fact(n - 1)\n#endmacro\n    mov a, fact(3)\n", []string{"mov a, 6"}},
+		{"variadic", "#macro emit values...\n#for v in values\n    out v\n#endfor\n#endmacro\n    emit 1, 2, 3\n", []string{"out 1", "out 2", "out 3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := preprocess(t, "section .text\n"+tt.src)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMacroHeaderComment(t *testing.T) {
+	prog, err := NewParser(strings.NewReader("section .text\n#macro inc x ; note\n    add a, x\n#endmacro\n    inc 1\n")).ParseFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	macro, ok := prog.sections[0].sectionContent.elements[0].(Macro)
+	if !ok || macro.comment != "; note" || len(macro.body.elements) != 1 {
+		t.Fatalf("got %#v, want macro with comment and one statement", prog.sections[0].sectionContent.elements[0])
+	}
+	out, err := NewPreprocessor().Process(prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range out.sections[0].sectionContent.elements {
+		if _, ok := stmt.(Comment); ok {
+			t.Errorf("comment of #macro line is expanded")
+		}
+	}
+}
+
+func TestMacroLabelCollision(t *testing.T) {
+	_, err := NewParser(strings.NewReader("section .text\nloop:\n#macro loop\n#endmacro\n")).ParseFile()
+	if err == nil || strings.Count(err.Error(), "already exists") != 1 {
+		t.Errorf("got error %v, want one collision", err)
+	}
+}
+
+func TestMacroCallArgs(t *testing.T) {
+	const macro = "section .text\n#macro m x, y = 0\n    out x\n#endmacro\n"
+	tests := []struct {
+		src  string
+		args []string
+		err  string
+	}{
+		{"    m a\n", []string{"a"}, ""},
+		{"    m b, 1\n", []string{"b", "1"}, ""},
+		{"    m pc 1 + 2\n", []string{"pc", "1 + 2"}, ""},
+		{"    m : 2\n", nil, `5:7: macro argument expected, met ":"`},
+		{"    m 1, ]\n", nil, `5:10: macro argument expected, met "]"`},
+	}
+	for _, tt := range tests {
+		prog, err := NewParser(strings.NewReader(macro + tt.src)).ParseFile()
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: got error %v, want %q", tt.src, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+			continue
+		}
+		call, ok := prog.sections[0].sectionContent.elements[1].(MacroCall)
+		if !ok {
+			t.Errorf("%q: macro call expected, met %T", tt.src, prog.sections[0].sectionContent.elements[1])
+			continue
+		}
+		var args []string
+		for _, arg := range call.args {
+			args = append(args, identString(arg))
+		}
+		if strings.Join(args, "|") != strings.Join(tt.args, "|") {
+			t.Errorf("%q: got arguments %q, want %q", tt.src, args, tt.args)
+		}
+	}
+	//register argument counts for arity
+	if got := preprocess(t, "section .text\n#macro m x\n    out 1\n#endmacro\n    m a\n"); strings.Join(got, "\n") != "out 1" {
+		t.Errorf("m a: got %q, want out 1", got)
+	}
+}
+
+func TestParseRecovery(t *testing.T) {
+	src := "section .text\n    add q, 1\n    mov a, b\n    foo bar\n    out 1\n    in a\n"
+	prog, err := NewParser(strings.NewReader(src)).ParseFile()
+	list, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("got error %v, want ErrorList", err)
+	}
+	want := []string{`2:9: expected register, met "q"`, `4:5: preprocessor directive expected, met variable: "foo"`}
+	if len(list) != len(want) {
+		t.Fatalf("got errors %q, want %q", list.Error(), want)
+	}
+	for i, e := range list {
+		if e.Error() != want[i] {
+			t.Errorf("error %d: got %q, want %q", i, e.Error(), want[i])
+		}
+	}
+	//statements around errors are kept
+	var got []string
+	for _, stmt := range prog.sections[0].sectionContent.elements {
+		if op, ok := stmt.(Opcode); ok {
+			got = append(got, opcodeString(op))
+		}
+	}
+	if wantOps := []string{"mov a, b", "out 1", "in a"}; strings.Join(got, "\n") != strings.Join(wantOps, "\n") {
+		t.Errorf("got %q, want %q", got, wantOps)
+	}
+}
+
+func TestParseComments(t *testing.T) {
+	src := "; head\nsection .text\n    add a, 1 // tail\n/* block\n   two */ mov a, 2 ; t2\n    out 1 /* b */\n"
+	prog, err := NewParser(strings.NewReader(src)).ParseFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"; head", "add a, 1", "// tail trailing", "/* block\n   two */", "mov a, 2", "; t2 trailing", "out 1", "/* b */ trailing"}
+	var got []string
+	for _, section := range prog.sections {
+		for _, stmt := range section.sectionContent.elements {
+			switch v := stmt.(type) {
+			case Comment:
+				if v.trailing {
+					got = append(got, v.text+" trailing")
+				} else {
+					got = append(got, v.text)
+				}
+			case Opcode:
+				got = append(got, opcodeString(v))
+			}
+		}
+	}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	_, err = NewParser(strings.NewReader("section .text\n/* open\n")).ParseFile()
+	if err == nil || !strings.Contains(err.Error(), "unterminated comment") {
+		t.Errorf("got error %v, want unterminated comment", err)
+	}
+}
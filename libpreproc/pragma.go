@@ -0,0 +1,108 @@
+package libpreproc
+
+import (
+	"fmt"
+	"strings"
+)
+
+//PragmaHandler - evaluates arguments of #pragma
+type PragmaHandler func(pp *Preprocessor, args []string) error
+
+var pragmas = map[string]PragmaHandler{}
+
+func init() {
+	for name, handler := range map[string]PragmaHandler{
+		"once":          pragmaOnce,
+		"arch":          pragmaArch,
+		"section-align": pragmaSectionAlign,
+		"warning":       pragmaWarning,
+	} {
+		if err := RegisterPragma(name, handler); err != nil {
+			panic(err)
+		}
+	}
+}
+
+//RegisterPragma adds handler of #pragma name
+func RegisterPragma(name string, handler PragmaHandler) error {
+	if _, dup := pragmas[name]; dup {
+		return fmt.Errorf("pragma %q is already registered", name)
+	}
+	pragmas[name] = handler
+	return nil
+}
+
+//Warning modes set by #pragma warning
+const (
+	warningsOn = iota
+	warningsOff
+	warningsError
+)
+
+//pragmaArgs returns error unless number of arguments is n
+func pragmaArgs(args []string, n int) error {
+	if len(args) != n {
+		return fmt.Errorf("%d arguments expected, got %d", n, len(args))
+	}
+	return nil
+}
+
+//pragmaOnce - #pragma once. Preprocessor expands every file once however
+//many times it is imported, so nothing is left to do
+func pragmaOnce(pp *Preprocessor, args []string) error {
+	return pragmaArgs(args, 0)
+}
+
+//pragmaArch - #pragma arch <name>, program requires the processor.
+//__ARCH__ is set to its name
+func pragmaArch(pp *Preprocessor, args []string) error {
+	if err := pragmaArgs(args, 1); err != nil {
+		return err
+	}
+	profile, err := LookupProfile(args[0])
+	if err != nil {
+		return err
+	}
+	if pp.arch != "" && pp.arch != profile.Name {
+		return fmt.Errorf("architecture is already set to %s", pp.arch)
+	}
+	pp.arch = profile.Name
+	pp.defines[predefArch] = SimpleString{value: profile.Name}
+	return nil
+}
+
+//pragmaSectionAlign - #pragma section-align <n>, current section starts
+//at address which is a multiple of n
+func pragmaSectionAlign(pp *Preprocessor, args []string) error {
+	if err := pragmaArgs(args, 1); err != nil {
+		return err
+	}
+	align, err := pp.Evaluate(args[0])
+	if err != nil {
+		return err
+	}
+	if align < 1 {
+		return fmt.Errorf("invalid alignment %d", align)
+	}
+	pp.align = align
+	return nil
+}
+
+//pragmaWarning - #pragma warning on|off|error, warnings are reported,
+//ignored or turned into errors
+func pragmaWarning(pp *Preprocessor, args []string) error {
+	if err := pragmaArgs(args, 1); err != nil {
+		return err
+	}
+	switch strings.ToLower(args[0]) {
+	case "on":
+		pp.warnMode = warningsOn
+	case "off":
+		pp.warnMode = warningsOff
+	case "error":
+		pp.warnMode = warningsError
+	default:
+		return fmt.Errorf("on, off or error expected, met %q", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package libpreproc
+
+import (
+	"strings"
+	"testing"
+)
+
+//processWarnings preprocesses file source, returns warnings and error
+func processWarnings(t *testing.T, src string) (Program, []string, error) {
+	t.Helper()
+	prog, err := NewFileParser(strings.NewReader(src), "f.s").ParseFile()
+	if err != nil {
+		return prog, nil, err
+	}
+	pp := NewPreprocessor()
+	out, err := pp.Process(prog)
+	return out, pp.Warnings(), err
+}
+
+func TestLine(t *testing.T) {
+	src := "section .text\n#line 10 \"asm.s\"\n#warn __FILE__\n#warn __LINE__\n#line 5 ; keeps file\n#warn __LINE__\n#line 20 b.s\n#error __FILE__\n"
+	_, warnings, err := processWarnings(t, src)
+	if want := "b.s:20:1: #error: b.s"; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+	want := []string{"asm.s:10:1: asm.s", "asm.s:11:1: 11", "asm.s:5:1: 5"}
+	if strings.Join(warnings, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got warnings %q, want %q", warnings, want)
+	}
+	for _, src := range []string{"#line 0", "#line x", "#line 7 a.s x"} {
+		if _, _, err := processWarnings(t, "section .text\n"+src+"\n"); err == nil {
+			t.Errorf("%s: error expected", src)
+		}
+	}
+}
+
+func TestPragma(t *testing.T) {
+	src := `section .text
+#pragma foo
+#pragma warning off
+#warn "hidden"
+#pragma warning on
+#pragma arch TD4E
+#warn __ARCH__
+#pragma section-align 4
+#pragma once
+`
+	out, warnings, err := processWarnings(t, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`f.s:2:1: unknown pragma "foo"`, "f.s:7:1: td4e"}
+	if strings.Join(warnings, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got warnings %q, want %q", warnings, want)
+	}
+	if out.Arch() != "td4e" || out.sections[0].align != 4 {
+		t.Errorf("got arch %q align %d, want td4e 4", out.Arch(), out.sections[0].align)
+	}
+}
+
+func TestPragmaErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"#pragma arch td4e\n#pragma arch td4", "f.s:3:1: #pragma arch: architecture is already set to td4e"},
+		{"#pragma arch z80", `f.s:2:1: #pragma arch: unknown architecture "z80", known are td4, td4e, td4e8, td8`},
+		{"#pragma warning error\n#warn \"x\"", "f.s:3:1: x"},
+		{"#pragma warning loud", `f.s:2:1: #pragma warning: on, off or error expected, met "loud"`},
+		{"#pragma once 1", "f.s:2:1: #pragma once: 0 arguments expected, got 1"},
+		{"#pragma section-align 0", "f.s:2:1: #pragma section-align: invalid alignment 0"},
+	}
+	for _, tt := range tests {
+		_, _, err := processWarnings(t, "section .text\n"+tt.src+"\n")
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%q: got error %v, want %q", tt.src, err, tt.want)
+		}
+	}
+	if err := RegisterPragma("once", pragmaOnce); err == nil {
+		t.Error("duplicate pragma: error expected")
+	}
+}
@@ -1 +1,975 @@
 package libpreproc
+
+import (
+	"fmt"
+	"strings"
+)
+
+//Predefined names. __FILE__, __LINE__ and __SECTION__ depend on the place
+//of use, __ARCH__ and __BUILD__ are set by Options
+const (
+	predefFile    = "__FILE__"
+	predefLine    = "__LINE__"
+	predefSection = "__SECTION__"
+	predefArch    = "__ARCH__"
+	predefBuild   = "__BUILD__"
+)
+
+//Options - preprocessor settings given outside of source
+type Options struct {
+	//Defines maps names to values parsed as #define values do,
+	//empty value defines name only
+	Defines map[string]string
+	//Undefines lists names removed after Defines are set
+	Undefines []string
+	//Arch is value of __ARCH__, usually ARCHITECTURE of linker script
+	Arch string
+	//Build is value of __BUILD__ build counter
+	Build int
+	//MaxMacroDepth limits nesting of macro calls, 0 means DefaultMacroDepth
+	MaxMacroDepth int
+	//MaxIterations limits iterations of every #rept, #for and #while,
+	//0 means DefaultMaxIterations
+	MaxIterations int
+}
+
+//DefaultMacroDepth - default limit of nested macro calls, it stops
+//infinite recursion
+const DefaultMacroDepth = 64
+
+//DefaultMaxIterations - default limit of iterations of a loop, it stops
+//infinite #while
+const DefaultMaxIterations = 4096
+
+//Preprocessor - evaluates preprocessor directives of a parsed program
+type Preprocessor struct {
+	defines  map[string]Ident
+	macros   map[string][]Macro //overloads by number of arguments
+	varargs  map[string][]Ident //bound variadic parameters
+	warnings []string
+	warnMode int    //set by #pragma warning
+	section  string //name of section being processed
+	align    int    //alignment of section being processed
+	arch     string //architecture required by #pragma arch
+	maxDepth int
+	maxIter  int
+	calls    []MacroFrame //macro calls being expanded
+	ret      Ident        //value of #return of macro being expanded
+	emitted  []Stmt       //statements of macros called inside operands
+	//expansion is number of macro expansion being processed, it makes
+	//names of its local labels unique
+	expansion  int
+	expansions int
+	anons      int //anonymous labels met
+	forward    Pos //the last @f and number of label it refers to
+	forwardTo  int
+	loader     *Loader
+	importing  []string        //paths of files being evaluated
+	imported   map[string]bool //paths of files evaluated once
+}
+
+//NewPreprocessor returns a new instance of Preprocessor
+func NewPreprocessor() *Preprocessor {
+	return &Preprocessor{
+		defines:  make(map[string]Ident),
+		macros:   make(map[string][]Macro),
+		varargs:  make(map[string][]Ident),
+		imported: make(map[string]bool),
+		maxDepth: DefaultMacroDepth,
+		maxIter:  DefaultMaxIterations,
+	}
+}
+
+//NewPreprocessorWithOptions returns a new instance of Preprocessor with
+//predefined __ARCH__, __BUILD__ and names given by options
+func NewPreprocessorWithOptions(opts Options) (*Preprocessor, error) {
+	pp := NewPreprocessor()
+	pp.defines[predefArch] = SimpleString{value: opts.Arch}
+	pp.defines[predefBuild] = Number{value: opts.Build}
+	if opts.MaxMacroDepth > 0 {
+		pp.maxDepth = opts.MaxMacroDepth
+	}
+	if opts.MaxIterations > 0 {
+		pp.maxIter = opts.MaxIterations
+	}
+	for name, value := range opts.Defines {
+		if err := pp.Define(name, value); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range opts.Undefines {
+		pp.Undefine(name)
+	}
+	return pp, nil
+}
+
+//Warnings returns messages collected from #warn directives and
+//unknown pragmas
+func (pp *Preprocessor) Warnings() []string {
+	return pp.warnings
+}
+
+//warn adds warning unless warnings are turned off by #pragma warning,
+//error is returned if they are turned into errors
+func (pp *Preprocessor) warn(pos Pos, format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	switch pp.warnMode {
+	case warningsOff:
+		return nil
+	case warningsError:
+		return errorAt(pos, "%s", msg)
+	}
+	pp.warnings = append(pp.warnings, fmt.Sprintf("%s: %s", pos, msg))
+	return nil
+}
+
+//Define defines name before processing as #define does. Value is parsed
+//as a number, a quoted string or a name; empty value defines name only
+func (pp *Preprocessor) Define(name string, value string) error {
+	if value == "" {
+		pp.defines[name] = nil
+		return nil
+	}
+	p := NewFileParser(strings.NewReader(value), "<command line>")
+	definition, err := p.ParseIdent()
+	if err != nil {
+		return err
+	}
+	if !p.lineEnd() {
+		return fmt.Errorf("invalid value %q of %s", value, name)
+	}
+	pp.defines[name] = definition
+	return nil
+}
+
+//Evaluate parses expression and evaluates it with current definitions
+func (pp *Preprocessor) Evaluate(expr string) (int, error) {
+	p := NewFileParser(strings.NewReader(expr), "<expression>")
+	id, err := p.ParseIdent()
+	if err != nil {
+		return 0, err
+	}
+	if !p.lineEnd() {
+		return 0, fmt.Errorf("invalid expression %q", expr)
+	}
+	return pp.numberValue(id)
+}
+
+//Undefine removes definition as #undef does
+func (pp *Preprocessor) Undefine(name string) {
+	delete(pp.defines, name)
+}
+
+//Process walks the program, evaluates all directives and expands macro
+//calls. Resulting program contains only opcodes, labels and data.
+func (pp *Preprocessor) Process(prog Program) (Program, error) {
+	var out Program
+	for _, section := range prog.sections {
+		pp.section, pp.align = section.sectionName, 0
+		content, err := pp.processBlock(section.sectionContent)
+		if err == ErrMacroEnd {
+			return out, fmt.Errorf("#return outside of macro")
+		}
+		if err != nil {
+			return out, err
+		}
+		out.sections = append(out.sections, splitSections(Section{sectionName: section.sectionName, align: pp.align, pos: section.pos}, content)...)
+	}
+	out.sections = mergeUnnamed(out.sections)
+	if err := pp.checkForward(); err != nil {
+		return out, err
+	}
+	out.arch = pp.arch
+	return out, nil
+}
+
+//ProcessModule evaluates the main program of module. Imported files are
+//evaluated where their #import appears, so definitions and conditions
+//around the directive apply to them
+func (pp *Preprocessor) ProcessModule(m Module) (Program, error) {
+	pp.loader = m.loader
+	pp.importing = []string{m.path}
+	pp.imported[m.path] = true
+	return pp.Process(m.main)
+}
+
+//sectionStart marks the place inside processed block where statements of
+//another section begin, imported files bring their sections so
+type sectionStart struct {
+	section Section
+}
+
+func (v sectionStart) Pos() Pos { return v.section.pos }
+func (sectionStart) Kind() Kind { return KindSection }
+func (sectionStart) node()      {}
+func (sectionStart) stmtNode()  {}
+
+//splitSections splits processed content of section at sectionStart marks.
+//Sections left empty after a mark are dropped
+func splitSections(section Section, content Block) []Section {
+	sections := []Section{section}
+	for _, stmt := range content.elements {
+		if start, ok := stmt.(sectionStart); ok {
+			sections = append(sections, start.section)
+			continue
+		}
+		last := &sections[len(sections)-1]
+		last.sectionContent.elements = append(last.sectionContent.elements, stmt)
+	}
+	out := sections[:1]
+	for _, s := range sections[1:] {
+		if len(s.sectionContent.elements) != 0 {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+//mergeUnnamed moves statements of unnamed sections into the first one, the
+//only place where source can have statements out of section
+func mergeUnnamed(sections []Section) []Section {
+	var out []Section
+	for _, section := range sections {
+		switch {
+		case section.sectionName != "":
+			out = append(out, section)
+		case len(out) == 0 || out[0].sectionName != "":
+			out = append([]Section{section}, out...)
+		default:
+			first := &out[0]
+			first.sectionContent.elements = append(append([]Stmt{}, first.sectionContent.elements...), section.sectionContent.elements...)
+			if section.align > first.align {
+				first.align = section.align
+			}
+		}
+	}
+	return out
+}
+
+//processImport evaluates imported file once. Statements before its first
+//section continue the importing section, its sections are placed after
+//them and the importing section resumes after the file
+func (pp *Preprocessor) processImport(v Import) ([]Stmt, error) {
+	if pp.loader == nil {
+		return nil, fmt.Errorf("#import %s needs module loaded by Loader", identString(v.name))
+	}
+	from := ""
+	if len(pp.importing) != 0 {
+		from = pp.importing[len(pp.importing)-1]
+	}
+	path, file, err := pp.loader.loadImport(messageText(v.name), from)
+	if err != nil {
+		return nil, err
+	}
+	for i, importing := range pp.importing {
+		if importing == path {
+			cycle := append(append([]string{}, pp.importing[i:]...), path)
+			return nil, fmt.Errorf("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	if pp.imported[path] {
+		return nil, nil
+	}
+	pp.imported[path] = true
+	pp.importing = append(pp.importing, path)
+	section, align := pp.section, pp.align
+	defer func() {
+		pp.importing = pp.importing[:len(pp.importing)-1]
+		pp.section, pp.align = section, align
+	}()
+	var out []Stmt
+	sectioned := false
+	for i, imported := range file.prog.sections {
+		if i == 0 && imported.sectionName == "" {
+			blk, err := pp.processBlock(imported.sectionContent)
+			out = append(out, blk.elements...)
+			if err != nil {
+				return out, err
+			}
+			continue
+		}
+		pp.section, pp.align = imported.sectionName, 0
+		sectioned = true
+		blk, err := pp.processBlock(imported.sectionContent)
+		start := Section{sectionName: imported.sectionName, align: pp.align, pos: imported.pos}
+		out = append(append(out, sectionStart{start}), blk.elements...)
+		if err != nil {
+			return out, err
+		}
+	}
+	if sectioned {
+		out = append(out, sectionStart{Section{sectionName: section, align: align, pos: v.pos}})
+	}
+	return out, nil
+}
+
+//processBlock evaluates every statement of the block. ErrMacroEnd is
+//returned together with the already evaluated part when #return is met.
+func (pp *Preprocessor) processBlock(blk Block) (Block, error) {
+	var out Block
+	for _, stmt := range blk.elements {
+		stmts, err := pp.processStmt(stmt)
+		//bodies of macros called inside operands go before the statement
+		out.elements = append(out.elements, pp.emitted...)
+		pp.emitted = nil
+		out.elements = append(out.elements, stmts...)
+		if err == ErrMacroEnd {
+			return out, err
+		}
+		if err != nil {
+			return out, withPos(posOf(stmt), err)
+		}
+	}
+	return out, nil
+}
+
+func (pp *Preprocessor) processStmt(stmt Stmt) ([]Stmt, error) {
+	switch v := stmt.(type) {
+	case Define:
+		name, err := definitionName(v.name)
+		if err != nil {
+			return nil, err
+		}
+		pp.defines[name] = v.definition
+	case Undef:
+		name, err := definitionName(v.definition)
+		if err != nil {
+			return nil, err
+		}
+		delete(pp.defines, name)
+	case Pext:
+		name, err := definitionName(v.pextName)
+		if err != nil {
+			return nil, err
+		}
+		pp.defines[name] = v.pextAddress
+	case Sumdef:
+		return nil, pp.arithDefine(v.def1, v.def2, 1)
+	case Resdef:
+		return nil, pp.arithDefine(v.def1, v.def2, -1)
+	case Ifdef:
+		name, err := definitionName(v.definition)
+		if err != nil {
+			return nil, err
+		}
+		return pp.processBranch(pp.isDefined(name), v.bodyTrue, v.bodyFalse)
+	case Ifndef:
+		name, err := definitionName(v.definition)
+		if err != nil {
+			return nil, err
+		}
+		return pp.processBranch(!pp.isDefined(name), v.bodyTrue, v.bodyFalse)
+	case If:
+		condition, err := pp.substitute(v.condition)
+		if err != nil {
+			return nil, err
+		}
+		value, err := NumberValue(condition)
+		if err != nil {
+			return nil, fmt.Errorf("#if condition: %v", err)
+		}
+		return pp.processBranch(value != 0, v.bodyTrue, v.bodyFalse)
+	case Warn:
+		msg, err := pp.message(v.message)
+		if err != nil {
+			return nil, err
+		}
+		return nil, pp.warn(v.pos, "%s", msg)
+	case Pragma:
+		handler, ok := pragmas[v.name]
+		if !ok {
+			return nil, pp.warn(v.pos, "unknown pragma %q", v.name)
+		}
+		if err := handler(pp, v.args); err != nil {
+			return nil, fmt.Errorf("#pragma %s: %v", v.name, err)
+		}
+	case Error:
+		msg, err := pp.message(v.message)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("#error: %s", msg)
+	case Import:
+		return pp.processImport(v)
+	case Line:
+		//line numbers are resolved by parser
+	case Macro:
+		return nil, pp.defineMacro(v)
+	case For:
+		return pp.processFor(v)
+	case Rept:
+		return pp.processRept(v)
+	case While:
+		return pp.processWhile(v)
+	case MacroCall:
+		stmts, _, err := pp.expandMacro(v)
+		return stmts, err
+	case Return:
+		if v.returnValue != nil {
+			value, err := pp.substitute(v.returnValue)
+			if err != nil {
+				return nil, err
+			}
+			pp.ret = value
+		}
+		return nil, ErrMacroEnd
+	case Add:
+		value, err := pp.substitute(v.value)
+		if err != nil {
+			return nil, err
+		}
+		v.value = value
+		return []Stmt{v}, nil
+	case Mov:
+		fa, err := pp.substitute(v.fa)
+		if err != nil {
+			return nil, err
+		}
+		v.fa = fa
+		return []Stmt{v}, nil
+	case In:
+		fa, err := pp.substitute(v.fa)
+		if err != nil {
+			return nil, err
+		}
+		v.fa = fa
+		return []Stmt{v}, nil
+	case Out:
+		fa, err := pp.substitute(v.fa)
+		if err != nil {
+			return nil, err
+		}
+		v.fa = fa
+		return []Stmt{v}, nil
+	case Cmp:
+		op, err := pp.substitute(v.operation)
+		if err != nil {
+			return nil, err
+		}
+		v.operation = op
+		return []Stmt{v}, nil
+	case Jmp:
+		addr, err := pp.substitute(v.addr)
+		if err != nil {
+			return nil, err
+		}
+		v.addr = addr
+		return []Stmt{v}, nil
+	case Jnc:
+		addr, err := pp.substitute(v.addr)
+		if err != nil {
+			return nil, err
+		}
+		v.addr = addr
+		return []Stmt{v}, nil
+	case Data:
+		values := make([]Ident, len(v.values))
+		for i, value := range v.values {
+			sub, err := pp.substitute(value)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = sub
+		}
+		v.values = values
+		return []Stmt{v}, nil
+	case Reserve:
+		size, err := pp.substitute(v.size)
+		if err != nil {
+			return nil, err
+		}
+		v.size = size
+		return []Stmt{v}, nil
+	case Label:
+		label, err := pp.renameLabel(v, true)
+		if err != nil {
+			return nil, err
+		}
+		return []Stmt{label}, nil
+	case Number, SimpleString, Comment:
+		return []Stmt{v}, nil
+	default:
+		return nil, fmt.Errorf("cannot preprocess statement %T", stmt)
+	}
+	//directives leave nothing in output
+	return nil, nil
+}
+
+func (pp *Preprocessor) processBranch(cond bool, bodyTrue Block, bodyFalse Block) ([]Stmt, error) {
+	body := bodyFalse
+	if cond {
+		body = bodyTrue
+	}
+	blk, err := pp.processBlock(body)
+	return blk.elements, err
+}
+
+//expandMacro binds call arguments to macro parameters and evaluates
+//macro body. It returns statements of the body and value of #return,
+//which is nil if macro returns nothing
+func (pp *Preprocessor) expandMacro(call MacroCall) ([]Stmt, Ident, error) {
+	args := pp.spread(call.args)
+	macro, err := pp.lookupMacro(call.macroName, len(args))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(pp.calls) >= pp.maxDepth {
+		return nil, nil, fmt.Errorf("macro calls are nested deeper than %d", pp.maxDepth)
+	}
+	values := make([]Ident, len(args))
+	for i, arg := range args {
+		value, err := pp.substitute(arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		values[i] = value
+	}
+	saved, err := pp.bindParams(macro, values)
+	if err != nil {
+		pp.restore(saved)
+		return nil, nil, err
+	}
+	frame := MacroFrame{Name: call.macroName, Pos: call.pos}
+	pp.calls = append(pp.calls, frame)
+	emitted, ret, expansion := pp.emitted, pp.ret, pp.expansion
+	pp.expansions++
+	pp.emitted, pp.ret, pp.expansion = nil, nil, pp.expansions
+	blk, err := pp.processBlock(macro.body)
+	value := pp.ret
+	pp.emitted, pp.ret, pp.expansion = emitted, ret, expansion
+	pp.calls = pp.calls[:len(pp.calls)-1]
+	pp.restore(saved)
+	if err == ErrMacroEnd {
+		err = nil
+	}
+	if err != nil {
+		return nil, nil, inMacro(err, frame)
+	}
+	return blk.elements, value, nil
+}
+
+//spread replaces variadic parameters passed as arguments by their values
+func (pp *Preprocessor) spread(args []Ident) []Ident {
+	var out []Ident
+	for _, arg := range args {
+		if v, ok := arg.(Variable); ok {
+			if list, ok := pp.varargs[v.name]; ok {
+				out = append(out, list...)
+				continue
+			}
+		}
+		out = append(out, arg)
+	}
+	return out
+}
+
+//defineMacro adds macro or its overload. Overloads must take different
+//numbers of arguments, macro taking the same ones replaces the old one
+func (pp *Preprocessor) defineMacro(macro Macro) error {
+	overloads := append([]Macro{}, pp.macros[macro.macroName]...)
+	min, max := macro.arity()
+	for i, old := range overloads {
+		oldMin, oldMax := old.arity()
+		if oldMin == min && oldMax == max {
+			overloads[i] = macro
+			pp.macros[macro.macroName] = overloads
+			return nil
+		}
+		if (max < 0 || oldMin <= max) && (oldMax < 0 || min <= oldMax) {
+			return fmt.Errorf("macro %q taking %s arguments overlaps with the one defined at %s taking %s", macro.macroName, arityString(min, max), old.pos, arityString(oldMin, oldMax))
+		}
+	}
+	pp.macros[macro.macroName] = append(overloads, macro)
+	return nil
+}
+
+//lookupMacro returns overload of macro taking n arguments
+func (pp *Preprocessor) lookupMacro(name string, n int) (Macro, error) {
+	overloads, ok := pp.macros[name]
+	if !ok {
+		return Macro{}, fmt.Errorf("macro %q is not defined", name)
+	}
+	var arities []string
+	for _, macro := range overloads {
+		min, max := macro.arity()
+		if n >= min && (max < 0 || n <= max) {
+			return macro, nil
+		}
+		arities = append(arities, arityString(min, max))
+	}
+	if len(overloads) == 1 {
+		return Macro{}, fmt.Errorf("macro %q defined at %s expects %s arguments, got %d", name, overloads[0].pos, arities[0], n)
+	}
+	return Macro{}, fmt.Errorf("no overload of macro %q takes %d arguments, overloads take %s", name, n, strings.Join(arities, " or "))
+}
+
+//arity returns the least and the greatest number of arguments taken by
+//macro, max is -1 for variadic macro
+func (m Macro) arity() (min int, max int) {
+	min, max = len(m.args), len(m.args)
+	if m.variadic {
+		min, max = min-1, -1
+	}
+	for i, value := range m.defaults {
+		if value != nil {
+			min = i
+			break
+		}
+	}
+	return min, max
+}
+
+func arityString(min int, max int) string {
+	switch {
+	case max < 0:
+		return fmt.Sprintf("at least %d", min)
+	case min == max:
+		return fmt.Sprint(min)
+	}
+	return fmt.Sprintf("%d to %d", min, max)
+}
+
+//bindParams defines macro parameters. Omitted arguments take default
+//values evaluated after binding of the preceding parameters, variadic
+//parameter takes the rest of arguments. Hidden definitions are returned
+//to be restored
+func (pp *Preprocessor) bindParams(macro Macro, values []Ident) ([]binding, error) {
+	var saved []binding
+	for i, param := range macro.args {
+		saved = append(saved, pp.save(param))
+		switch {
+		case macro.variadic && i == len(macro.args)-1:
+			var rest []Ident
+			if i < len(values) {
+				rest = values[i:]
+			}
+			pp.bindList(param, rest)
+		case i < len(values):
+			pp.bind(param, values[i])
+		default:
+			value, err := pp.substitute(macro.defaults[i])
+			if err != nil {
+				return saved, fmt.Errorf("default value of %s: %v", param, err)
+			}
+			pp.bind(param, value)
+		}
+	}
+	return saved, nil
+}
+
+//binding - definition of name hidden by macro parameter or loop variable
+type binding struct {
+	name     string
+	value    Ident
+	defined  bool
+	list     []Ident
+	variadic bool
+}
+
+func (pp *Preprocessor) save(name string) binding {
+	value, defined := pp.defines[name]
+	list, variadic := pp.varargs[name]
+	return binding{name: name, value: value, defined: defined, list: list, variadic: variadic}
+}
+
+//restore brings back saved definitions in reverse order
+func (pp *Preprocessor) restore(saved []binding) {
+	for i := len(saved) - 1; i >= 0; i-- {
+		old := saved[i]
+		delete(pp.defines, old.name)
+		delete(pp.varargs, old.name)
+		if old.defined {
+			pp.defines[old.name] = old.value
+		}
+		if old.variadic {
+			pp.varargs[old.name] = old.list
+		}
+	}
+}
+
+func (pp *Preprocessor) bind(name string, value Ident) {
+	delete(pp.varargs, name)
+	pp.defines[name] = value
+}
+
+func (pp *Preprocessor) bindList(name string, list []Ident) {
+	delete(pp.defines, name)
+	pp.varargs[name] = list
+}
+
+//processFor evaluates body of #for for every value, variadic parameters
+//among values are spread. Loop variable is visible inside the body only
+func (pp *Preprocessor) processFor(loop For) ([]Stmt, error) {
+	name, err := definitionName(loop.variable)
+	if err != nil {
+		return nil, err
+	}
+	var values []Ident
+	for _, value := range pp.spread(loop.values) {
+		if r, ok := value.(Binary); ok && r.op == RANGE {
+			if values, err = pp.appendRange(values, r); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		sub, err := pp.substitute(value)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, sub)
+	}
+	if len(values) > pp.maxIter {
+		return nil, pp.iterError("#for")
+	}
+	saved := []binding{pp.save(name)}
+	defer pp.restore(saved)
+	var out []Stmt
+	for _, value := range values {
+		pp.bind(name, value)
+		blk, err := pp.processBlock(loop.body)
+		out = append(out, blk.elements...)
+		if err != nil {
+			return out, err
+		}
+	}
+	return out, nil
+}
+
+//appendRange appends numbers of range a..b, b < a gives descending range
+func (pp *Preprocessor) appendRange(values []Ident, r Binary) ([]Ident, error) {
+	from, err := pp.numberValue(r.x)
+	if err != nil {
+		return nil, err
+	}
+	to, err := pp.numberValue(r.y)
+	if err != nil {
+		return nil, err
+	}
+	step, n := 1, to-from+1
+	if to < from {
+		step, n = -1, from-to+1
+	}
+	if len(values)+n > pp.maxIter {
+		return nil, pp.iterError("#for")
+	}
+	for i := 0; i < n; i++ {
+		values = append(values, Number{value: from + i*step, pos: r.pos})
+	}
+	return values, nil
+}
+
+//processRept evaluates body of #rept count times. Counter variable is
+//visible inside the body only
+func (pp *Preprocessor) processRept(rept Rept) ([]Stmt, error) {
+	count, err := pp.numberValue(rept.count)
+	if err != nil {
+		return nil, fmt.Errorf("#rept count: %v", err)
+	}
+	if count < 0 {
+		return nil, fmt.Errorf("negative #rept count %d", count)
+	}
+	if count > pp.maxIter {
+		return nil, pp.iterError("#rept")
+	}
+	name := ""
+	if rept.variable != nil {
+		if name, err = definitionName(rept.variable); err != nil {
+			return nil, err
+		}
+		saved := []binding{pp.save(name)}
+		defer pp.restore(saved)
+	}
+	var out []Stmt
+	for i := 0; i < count; i++ {
+		if name != "" {
+			pp.bind(name, Number{value: i, pos: rept.pos})
+		}
+		blk, err := pp.processBlock(rept.body)
+		out = append(out, blk.elements...)
+		if err != nil {
+			return out, err
+		}
+	}
+	return out, nil
+}
+
+//processWhile evaluates body of #while while condition is not 0, the
+//condition is evaluated before every iteration
+func (pp *Preprocessor) processWhile(loop While) ([]Stmt, error) {
+	var out []Stmt
+	for i := 0; ; i++ {
+		cond, err := pp.numberValue(loop.condition)
+		if err != nil {
+			return out, fmt.Errorf("#while condition: %v", err)
+		}
+		if cond == 0 {
+			return out, nil
+		}
+		if i == pp.maxIter {
+			return out, pp.iterError("#while")
+		}
+		blk, err := pp.processBlock(loop.body)
+		out = append(out, blk.elements...)
+		if err != nil {
+			return out, err
+		}
+	}
+}
+
+func (pp *Preprocessor) iterError(directive string) error {
+	return fmt.Errorf("%s loop exceeds %d iterations", directive, pp.maxIter)
+}
+
+//arithDefine implements #sumdef (sign = 1) and #resdef (sign = -1)
+func (pp *Preprocessor) arithDefine(def1 Ident, def2 Ident, sign int) error {
+	name, err := definitionName(def1)
+	if err != nil {
+		return err
+	}
+	left, err := pp.numberValue(def1)
+	if err != nil {
+		return err
+	}
+	right, err := pp.numberValue(def2)
+	if err != nil {
+		return err
+	}
+	pp.defines[name] = Number{value: left + sign*right, pos: posOf(def1)}
+	return nil
+}
+
+func (pp *Preprocessor) numberValue(id Ident) (int, error) {
+	value, err := pp.substitute(id)
+	if err != nil {
+		return 0, err
+	}
+	return NumberValue(value)
+}
+
+func (pp *Preprocessor) isDefined(name string) bool {
+	switch name {
+	case predefFile, predefLine, predefSection:
+		return true
+	}
+	_, ok := pp.defines[name]
+	return ok
+}
+
+//substitute replaces defined names by their definitions, also inside
+//expressions. Expressions of numbers only are evaluated
+func (pp *Preprocessor) substitute(id Ident) (Ident, error) {
+	sub, err := pp.substituteExpr(id, make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+	return fold(sub), nil
+}
+
+//substituteExpr substitutes names, seen holds names being substituted
+func (pp *Preprocessor) substituteExpr(id Ident, seen map[string]bool) (Ident, error) {
+	var err error
+	switch v := id.(type) {
+	case Variable:
+		switch v.name {
+		case predefFile:
+			return SimpleString{value: v.pos.File, pos: v.pos}, nil
+		case predefLine:
+			return Number{value: v.pos.Line, pos: v.pos}, nil
+		case predefSection:
+			return SimpleString{value: pp.section, pos: v.pos}, nil
+		}
+		if _, ok := pp.varargs[v.name]; ok {
+			return nil, fmt.Errorf("variadic parameter %s may only be passed to macro or iterated by #for", v.name)
+		}
+		if isScopedLabel(v.name) {
+			return pp.renameLabel(Label{name: v, pos: v.pos}, false)
+		}
+		def, defined := pp.defines[v.name]
+		if !defined {
+			return id, nil
+		}
+		if seen[v.name] {
+			return nil, fmt.Errorf("recursive definition of %q", v.name)
+		}
+		if def == nil {
+			return nil, fmt.Errorf("%q is defined without value", v.name)
+		}
+		seen[v.name] = true
+		sub, err := pp.substituteExpr(def, seen)
+		delete(seen, v.name)
+		return sub, err
+	case Label:
+		return pp.renameLabel(v, false)
+	case Unary:
+		v.x, err = pp.substituteExpr(v.x, seen)
+		return v, err
+	case Binary:
+		if v.x, err = pp.substituteExpr(v.x, seen); err != nil {
+			return nil, err
+		}
+		v.y, err = pp.substituteExpr(v.y, seen)
+		return v, err
+	case MacroCall:
+		stmts, value, err := pp.expandMacro(v)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			return nil, fmt.Errorf("macro %q returns no value", v.macroName)
+		}
+		pp.emitted = append(pp.emitted, stmts...)
+		return value, nil
+	case Call:
+		if v.function == definedFunc {
+			name, err := definitionName(v.args[0])
+			if err != nil {
+				return nil, err
+			}
+			return Number{value: boolValue(pp.isDefined(name)), pos: v.pos}, nil
+		}
+		args := make([]Ident, len(v.args))
+		for i, arg := range v.args {
+			if args[i], err = pp.substituteExpr(arg, seen); err != nil {
+				return nil, err
+			}
+		}
+		v.args = args
+		return v, nil
+	}
+	return id, nil
+}
+
+//definitionName returns the name used by directive operand
+func definitionName(id Ident) (string, error) {
+	switch v := id.(type) {
+	case Variable:
+		return v.name, nil
+	case Label:
+		return definitionName(v.name)
+	}
+	return "", fmt.Errorf("name expected, met %q", messageText(id))
+}
+
+//message returns text of #warn or #error operand with names substituted
+func (pp *Preprocessor) message(id Ident) (string, error) {
+	sub, err := pp.substitute(id)
+	if err != nil {
+		return "", err
+	}
+	return messageText(sub), nil
+}
+
+//messageText returns text of #warn and #error messages
+func messageText(id Ident) string {
+	switch v := id.(type) {
+	case SimpleString:
+		return v.value
+	case Number:
+		return fmt.Sprint(v.value)
+	case Variable:
+		return v.name
+	case Label:
+		return messageText(v.name)
+	}
+	return identString(id)
+}
@@ -0,0 +1,235 @@
+package libpreproc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProcessDefines(t *testing.T) {
+	src := `section .text
+#define N 3
+#define M
+    add a, N
+#ifdef M
+    mov b, 1
+#else
+    mov b, 2
+#endif
+#ifndef M
+    out 1
+#endif
+#undef M
+#ifdef M
+    out 2
+#endif
+#sumdef N 2
+    add a, N
+#resdef N 1
+    add a, N
+`
+	want := []string{"add a, 3", "mov b, 1", "add a, 5", "add a, 4"}
+	if got := preprocess(t, src); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestProcessIf(t *testing.T) {
+	const branches = `#if N == 1
+    out 1
+#elif N == 2 && defined(N)
+    out 2
+#else
+    out 3
+#endif
+`
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{"if", "#define N 1\n" + branches, []string{"out 1"}},
+		{"elif", "#define N 2\n" + branches, []string{"out 2"}},
+		{"else", "#define N 3\n" + branches, []string{"out 3"}},
+		{"defined", "#define N\n#if !defined(M) && defined(N)\n    out 4\n#endif\n", []string{"out 4"}},
+		{"defined as value", "#if defined(N) + defined(M) == 0\n    out 5\n#endif\n", []string{"out 5"}},
+		{"strings", "#if \"a\" != \"b\"\n    out 6\n#endif\n", []string{"out 6"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := preprocess(t, "section .text\n"+tt.src)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+	_, err := NewParser(strings.NewReader("section .text\n#if 1\n#else\n#elif 1\n#endif\n")).ParseFile()
+	if err == nil || !strings.Contains(err.Error(), `4:1: unexpected "#elif"`) {
+		t.Errorf("#elif after #else: got error %v", err)
+	}
+}
+
+func TestProcessErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"#sumdef X 1", `2:1: unresolved symbol "X"`},
+		{"#resdef X 1", `2:1: unresolved symbol "X"`},
+		{"#if X\n#endif", `2:1: #if condition: unresolved symbol "X"`},
+		{"#if \"a\"\n#endif", `2:1: #if condition: number expected, met "a"`},
+		{"#error \"stop\"", "2:1: #error: stop"},
+	}
+	for _, tt := range tests {
+		prog, err := NewParser(strings.NewReader("section .text\n" + tt.src + "\n")).ParseFile()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.src, err)
+		}
+		_, err = NewPreprocessor().Process(prog)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%s: got error %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	pp, err := NewPreprocessorWithOptions(Options{
+		Defines:   map[string]string{"N": "2 + 1", "FLAG": "", "GONE": "1"},
+		Undefines: []string{"GONE"},
+		Arch:      "td4e",
+		Build:     7,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		expr string
+		want int
+	}{
+		{"N * 2", 6},
+		{"defined(FLAG)", 1},
+		{"defined(GONE)", 0},
+		{"__ARCH__ == \"td4e\"", 1},
+		{"__BUILD__", 7},
+	}
+	for _, tt := range tests {
+		got, err := pp.Evaluate(tt.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.expr, got, tt.want)
+		}
+	}
+	if _, err := NewPreprocessorWithOptions(Options{Defines: map[string]string{"N": "1 2"}}); err == nil {
+		t.Error("invalid define value: error expected")
+	}
+}
+
+func TestMessageSubstitution(t *testing.T) {
+	src := "section .text\n#define N 3\n#warn __LINE__\n#warn __SECTION__\n#warn N + 1\n#warn \"N\"\n#error __FILE__\n"
+	prog, err := NewFileParser(strings.NewReader(src), "w.s").ParseFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pp := NewPreprocessor()
+	_, err = pp.Process(prog)
+	if err == nil || err.Error() != "w.s:7:1: #error: w.s" {
+		t.Errorf("got error %v, want #error: w.s", err)
+	}
+	want := []string{"w.s:3:1: 3", "w.s:4:1: .text", "w.s:5:1: 4", "w.s:6:1: N"}
+	if strings.Join(pp.Warnings(), "\n") != strings.Join(want, "\n") {
+		t.Errorf("got warnings %q, want %q", pp.Warnings(), want)
+	}
+}
+
+//processError preprocesses source with options and returns error
+func processError(t *testing.T, src string, opts Options) error {
+	t.Helper()
+	prog, err := NewParser(strings.NewReader("section .text\n" + src + "\n")).ParseFile()
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	pp, err := NewPreprocessorWithOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = pp.Process(prog)
+	return err
+}
+
+func TestMacroDepthLimit(t *testing.T) {
+	const src = "#macro r x\n#if x < 10\n    r x + 1\n#endif\n#endmacro\n    r 1"
+	if err := processError(t, src, Options{}); err != nil {
+		t.Errorf("default limit: %v", err)
+	}
+	err := processError(t, src, Options{MaxMacroDepth: 3})
+	want := "4:5: macro calls are nested deeper than 3\n\tin macro r called at 4:5\n\tin macro r called at 4:5\n\tin macro r called at 7:5"
+	if err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+	//long backtrace is cut in the middle
+	err = processError(t, "#macro r\n    r\n#endmacro\n    r", Options{})
+	if e, ok := err.(*MacroError); !ok || len(e.Backtrace) != DefaultMacroDepth || !strings.Contains(err.Error(), "\t... 54 more calls\n") {
+		t.Errorf("got error %v, want backtrace of %d calls", err, DefaultMacroDepth)
+	}
+}
+
+func TestMacroErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"#return 1", "#return outside of macro"},
+		{"#macro n\n    out 1\n#endmacro\n    mov a, n()", `5:5: macro "n" returns no value`},
+		{"#macro e x\n    #error x\n#endmacro\n#macro f\n    e 2\n#endmacro\n    f", "3:5: #error: 2\n\tin macro e called at 6:5\n\tin macro f called at 8:5"},
+	}
+	for _, tt := range tests {
+		if err := processError(t, tt.src, Options{}); err == nil || err.Error() != tt.want {
+			t.Errorf("%q: got error %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestLoops(t *testing.T) {
+	src := `section .text
+#rept 2
+    out 1
+#endrept
+#rept 3, i
+    add a, i
+#endrept
+#for x in 3..1, 7
+    mov b, x
+#endfor
+#define n 2
+#while n > 0
+    out n
+    #resdef n 1
+#endwhile
+`
+	want := []string{"out 1", "out 1", "add a, 0", "add a, 1", "add a, 2", "mov b, 3", "mov b, 2", "mov b, 1", "mov b, 7", "out 2", "out 1"}
+	if got := preprocess(t, src); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLoopLimits(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"#while 1\n#endwhile", "2:1: #while loop exceeds 4 iterations"},
+		{"#rept 5\n#endrept", "2:1: #rept loop exceeds 4 iterations"},
+		{"#for i in 1..5\n#endfor", "2:1: #for loop exceeds 4 iterations"},
+		{"#rept -1\n#endrept", "2:1: negative #rept count -1"},
+	}
+	for _, tt := range tests {
+		if err := processError(t, tt.src, Options{MaxIterations: 4}); err == nil || err.Error() != tt.want {
+			t.Errorf("%q: got error %v, want %q", tt.src, err, tt.want)
+		}
+	}
+	if err := processError(t, "#rept 4\n#endrept", Options{MaxIterations: 4}); err != nil {
+		t.Errorf("4 iterations: %v", err)
+	}
+}
@@ -1,205 +1,338 @@
 package libpreproc
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"os"
+)
 
-var depth int
+//treePrinter - writes AST as indented tree, depth is nesting of blocks
+type treePrinter struct {
+	w     io.Writer
+	depth int
+}
 
 //PrintProg - prints program AST
 func PrintProg(prg Program) {
-	fmt.Printf("program\n")
-	depth = 0
+	FprintProg(os.Stdout, prg)
+}
+
+//FprintProg - writes program AST to w
+func FprintProg(w io.Writer, prg Program) {
+	tp := &treePrinter{w: w}
+	fmt.Fprintf(tp.w, "program\n")
 	for _, v := range prg.sections {
-		printSection(v)
+		tp.printSection(v)
 	}
 }
 
-func printSection(sec Section) {
-	depth++
-	fmt.Printf("\tsection\n")
-	fmt.Printf("\t\tsection_name: %s\n", sec.sectionName)
-	fmt.Printf("\t\tsection_data:\n")
-	printBlock(sec.sectionContent)
-	depth--
+func (tp *treePrinter) printSection(sec Section) {
+	tp.depth++
+	fmt.Fprintf(tp.w, "\tsection\n")
+	fmt.Fprintf(tp.w, "\t\tsection_name: %s\n", sec.sectionName)
+	fmt.Fprintf(tp.w, "\t\tsection_data:\n")
+	tp.printBlock(sec.sectionContent)
+	tp.depth--
 }
 
-func printBlock(blk Block) {
+func (tp *treePrinter) printBlock(blk Block) {
 	for _, v := range blk.elements {
-		for i := 0; i < depth; i++ {
-			fmt.Printf("\t\t\t")
+		for i := 0; i < tp.depth; i++ {
+			fmt.Fprintf(tp.w, "\t\t\t")
 		}
-		estimateStmt(v)
+		tp.estimateStmt(v)
 	}
 }
 
-func estimateStmt(stmt interface{}) {
+func (tp *treePrinter) estimateStmt(stmt interface{}) {
 	switch stmt.(type) {
 	case Define:
 		v, _ := stmt.(Define)
-		printDefine(v)
+		tp.printDefine(v)
 	case Import:
 		v, _ := stmt.(Import)
-		printImport(v)
-	// case Line:
-	// 	v, _ := stmt.(Line)
-	// 	printLine(v)
+		tp.printImport(v)
+	case Line:
+		v, _ := stmt.(Line)
+		tp.printLine(v)
+	case Pragma:
+		v, _ := stmt.(Pragma)
+		tp.printPragma(v)
 	case Warn:
 		v, _ := stmt.(Warn)
-		printWarn(v)
+		tp.printWarn(v)
 	case Sumdef:
 		v, _ := stmt.(Sumdef)
-		printSumdef(v)
+		tp.printSumdef(v)
 	case Resdef:
 		v, _ := stmt.(Resdef)
-		printResdef(v)
+		tp.printResdef(v)
 	case Pext:
 		v, _ := stmt.(Pext)
-		printPext(v)
+		tp.printPext(v)
 	case Error:
 		v, _ := stmt.(Error)
-		printError(v)
+		tp.printError(v)
 	case Undef:
 		v, _ := stmt.(Undef)
-		printUndef(v)
+		tp.printUndef(v)
 	case Ifdef:
 		v, _ := stmt.(Ifdef)
-		printIfdef(v)
+		tp.printIfdef(v)
 	case Ifndef:
 		v, _ := stmt.(Ifndef)
-		printIfndef(v)
+		tp.printIfndef(v)
+	case If:
+		v, _ := stmt.(If)
+		tp.printIf(v)
 	case Label:
 		v, _ := stmt.(Label)
-		printLabel(v)
+		tp.printLabel(v)
 	case Macro:
 		v, _ := stmt.(Macro)
-		printMacro(v)
+		tp.printMacro(v)
+	case For:
+		v, _ := stmt.(For)
+		tp.printFor(v)
+	case Rept:
+		v, _ := stmt.(Rept)
+		tp.printRept(v)
+	case While:
+		v, _ := stmt.(While)
+		tp.printWhile(v)
+	case Return:
+		v, _ := stmt.(Return)
+		tp.printReturn(v)
 	case MacroCall:
 		v, _ := stmt.(MacroCall)
-		printMacroCall(v)
+		tp.printMacroCall(v)
 	case Add:
 		v, _ := stmt.(Add)
-		printAdd(v)
+		tp.printAdd(v)
 	case Mov:
 		v, _ := stmt.(Mov)
-		printMov(v)
+		tp.printMov(v)
+	case In:
+		v, _ := stmt.(In)
+		tp.printIn(v)
 	case Out:
 		v, _ := stmt.(Out)
-		printOut(v)
+		tp.printOut(v)
+	case Cmp:
+		v, _ := stmt.(Cmp)
+		tp.printCmp(v)
 	case Jmp:
 		v, _ := stmt.(Jmp)
-		printJmp(v)
+		tp.printJmp(v)
 	case Jnc:
 		v, _ := stmt.(Jnc)
-		printJnc(v)
+		tp.printJnc(v)
+	case Data:
+		v, _ := stmt.(Data)
+		tp.printData(v)
+	case Reserve:
+		v, _ := stmt.(Reserve)
+		tp.printReserve(v)
+	case Comment:
+		v, _ := stmt.(Comment)
+		tp.printComment(v)
+	case Number:
+		v, _ := stmt.(Number)
+		tp.printNumber(v)
+	case SimpleString:
+		v, _ := stmt.(SimpleString)
+		tp.printSimpleString(v)
+	case Location:
+		v, _ := stmt.(Location)
+		tp.printLocation(v)
 	}
 
 }
 
-func printDefine(define Define) {
-	fmt.Printf("define_directive: (%s -> %s)\n", define.definition, define.name)
+func (tp *treePrinter) printDefine(define Define) {
+	fmt.Fprintf(tp.w, "define_directive: (%s -> %s)\n", identString(define.definition), identString(define.name))
 }
 
-func printImport(imprt Import) {
-	fmt.Printf("import_directive: (%s)\n", imprt.name)
+func (tp *treePrinter) printImport(imprt Import) {
+	fmt.Fprintf(tp.w, "import_directive: (%s)\n", identString(imprt.name))
 }
 
-// func printLine(line Line) {
-// 	fmt.Printf("line_directive: from %s paste line %s\n", line.name, line.lineNumber)
-// }
+func (tp *treePrinter) printLine(line Line) {
+	fmt.Fprintf(tp.w, "line_directive: from %s paste line %s\n", identString(line.name), identString(line.lineNumber))
+}
 
-func printWarn(warn Warn) {
-	fmt.Printf("warn_directive: %s\n", warn.message)
+func (tp *treePrinter) printPragma(pragma Pragma) {
+	fmt.Fprintf(tp.w, "pragma: %s %s\n", pragma.name, pragma.args)
 }
 
-func printSumdef(sumdef Sumdef) {
-	fmt.Printf("sumdef: %s = %s + %s\n", sumdef.def1, sumdef.def1, sumdef.def2)
+func (tp *treePrinter) printWarn(warn Warn) {
+	fmt.Fprintf(tp.w, "warn_directive: %s\n", identString(warn.message))
 }
 
-func printResdef(resdef Resdef) {
-	fmt.Printf("resdef: %s = %s - %s\n", resdef.def1, resdef.def1, resdef.def2)
+func (tp *treePrinter) printSumdef(sumdef Sumdef) {
+	fmt.Fprintf(tp.w, "sumdef: %s = %s + %s\n", identString(sumdef.def1), identString(sumdef.def1), identString(sumdef.def2))
 }
 
-func printPext(pext Pext) {
-	fmt.Printf("pext: %s connects to %s\n", pext.pextName, pext.pextAddress)
+func (tp *treePrinter) printResdef(resdef Resdef) {
+	fmt.Fprintf(tp.w, "resdef: %s = %s - %s\n", identString(resdef.def1), identString(resdef.def1), identString(resdef.def2))
 }
 
-func printError(err Error) {
-	fmt.Printf("error: %s\n", err.message)
+func (tp *treePrinter) printPext(pext Pext) {
+	fmt.Fprintf(tp.w, "pext: %s connects to %s\n", identString(pext.pextName), identString(pext.pextAddress))
 }
 
-func printUndef(undef Undef) {
-	fmt.Printf("undefined: %s\n", undef.definition)
+func (tp *treePrinter) printError(err Error) {
+	fmt.Fprintf(tp.w, "error: %s\n", identString(err.message))
 }
 
-func printIfdef(ifdef Ifdef) {
-	fmt.Printf("if %s defined:\n", ifdef.definition)
-	depth++
-	printBlock(ifdef.bodyTrue)
-	depth--
+func (tp *treePrinter) printUndef(undef Undef) {
+	fmt.Fprintf(tp.w, "undefined: %s\n", identString(undef.definition))
+}
+
+func (tp *treePrinter) printIfdef(ifdef Ifdef) {
+	fmt.Fprintf(tp.w, "if %s defined:\n", identString(ifdef.definition))
+	tp.depth++
+	tp.printBlock(ifdef.bodyTrue)
+	tp.depth--
 	if len(ifdef.bodyFalse.elements) != 0 {
-		for i := 0; i < depth; i++ {
-			fmt.Printf("\t\t\t")
+		for i := 0; i < tp.depth; i++ {
+			fmt.Fprintf(tp.w, "\t\t\t")
 		}
-		fmt.Printf("else:\n")
-		depth++
-		printBlock(ifdef.bodyFalse)
-		depth--
+		fmt.Fprintf(tp.w, "else:\n")
+		tp.depth++
+		tp.printBlock(ifdef.bodyFalse)
+		tp.depth--
 	}
 }
 
-func printIfndef(ifndef Ifndef) {
-	fmt.Printf("if %s not defined:\n", ifndef.definition)
-	depth++
-	printBlock(ifndef.bodyTrue)
-	depth--
+func (tp *treePrinter) printIfndef(ifndef Ifndef) {
+	fmt.Fprintf(tp.w, "if %s not defined:\n", identString(ifndef.definition))
+	tp.depth++
+	tp.printBlock(ifndef.bodyTrue)
+	tp.depth--
 	if len(ifndef.bodyFalse.elements) != 0 {
-		for i := 0; i < depth; i++ {
-			fmt.Printf("\t\t\t")
+		for i := 0; i < tp.depth; i++ {
+			fmt.Fprintf(tp.w, "\t\t\t")
+		}
+		fmt.Fprintf(tp.w, "else:\n")
+		tp.depth++
+		tp.printBlock(ifndef.bodyFalse)
+		tp.depth--
+	}
+}
+
+func (tp *treePrinter) printIf(ifStmt If) {
+	fmt.Fprintf(tp.w, "if %s:\n", identString(ifStmt.condition))
+	tp.depth++
+	tp.printBlock(ifStmt.bodyTrue)
+	tp.depth--
+	if len(ifStmt.bodyFalse.elements) != 0 {
+		for i := 0; i < tp.depth; i++ {
+			fmt.Fprintf(tp.w, "\t\t\t")
 		}
-		fmt.Printf("else:\n")
-		depth++
-		printBlock(ifndef.bodyFalse)
-		depth--
+		fmt.Fprintf(tp.w, "else:\n")
+		tp.depth++
+		tp.printBlock(ifStmt.bodyFalse)
+		tp.depth--
 	}
 }
-func printLabel(label Label) {
-	fmt.Printf("Label: %s\n", label.name)
+
+func (tp *treePrinter) printLabel(label Label) {
+	fmt.Fprintf(tp.w, "Label: %s\n", identString(label.name))
+}
+
+func (tp *treePrinter) printMacro(macro Macro) {
+	fmt.Fprintf(tp.w, "macro %s: [%s] {\n", macro.macroName, paramsString(macro))
+	tp.depth++
+	tp.printBlock(macro.body)
+	tp.depth--
+	for i := 0; i < tp.depth; i++ {
+		fmt.Fprintf(tp.w, "\t\t\t")
+	}
+	fmt.Fprintf(tp.w, "}\n")
+}
+
+func (tp *treePrinter) printFor(loop For) {
+	fmt.Fprintf(tp.w, "for %s in %s {\n", identString(loop.variable), joinOperands(", ", loop.values...))
+	tp.printLoopBody(loop.body)
+}
+
+func (tp *treePrinter) printRept(rept Rept) {
+	fmt.Fprintf(tp.w, "rept %s {\n", joinOperands(", ", rept.count, rept.variable))
+	tp.printLoopBody(rept.body)
+}
+
+func (tp *treePrinter) printWhile(loop While) {
+	fmt.Fprintf(tp.w, "while %s {\n", identString(loop.condition))
+	tp.printLoopBody(loop.body)
 }
 
-func printMacro(macro Macro) {
-	fmt.Printf("macro %s: %s {\n", macro.macroName, macro.args)
-	depth++
-	printBlock(macro.body)
-	depth--
-	for i := 0; i < depth; i++ {
-		fmt.Printf("\t\t\t")
+func (tp *treePrinter) printLoopBody(body Block) {
+	tp.depth++
+	tp.printBlock(body)
+	tp.depth--
+	for i := 0; i < tp.depth; i++ {
+		fmt.Fprintf(tp.w, "\t\t\t")
 	}
-	fmt.Printf("}\n")
+	fmt.Fprintf(tp.w, "}\n")
+}
+
+func (tp *treePrinter) printReturn(ret Return) {
+	fmt.Fprintf(tp.w, "return_directive: %s\n", identString(ret.returnValue))
+}
+
+func (tp *treePrinter) printMacroCall(macrocall MacroCall) {
+	fmt.Fprintf(tp.w, "call: %s\n", identString(macrocall))
+}
+
+func (tp *treePrinter) printAdd(add Add) {
+	fmt.Fprintf(tp.w, "add %s, %s\n", add.reg, identString(add.value))
+}
+
+func (tp *treePrinter) printMov(mov Mov) {
+	fmt.Fprintf(tp.w, "mov %s, %s +%s\n", mov.reg1, mov.reg2, identString(mov.fa))
+}
+
+func (tp *treePrinter) printOut(out Out) {
+	fmt.Fprintf(tp.w, "out %s %s\n", out.reg, identString(out.fa))
+}
+
+func (tp *treePrinter) printIn(in In) {
+	fmt.Fprintf(tp.w, "in %s %s\n", in.reg, identString(in.fa))
+}
+
+func (tp *treePrinter) printCmp(cmp Cmp) {
+	fmt.Fprintf(tp.w, "cmp %s, %s, %s\n", cmp.regA, cmp.regB, identString(cmp.operation))
+}
+
+func (tp *treePrinter) printJmp(jmp Jmp) {
+	fmt.Fprintf(tp.w, "jmp %s|%s\n", jmp.regB, identString(jmp.addr))
 }
 
-func printMacroCall(macrocall MacroCall) {
-	fmt.Printf("call: %s %s\n", macrocall.macroName, macrocall.args)
+func (tp *treePrinter) printJnc(jnc Jnc) {
+	fmt.Fprintf(tp.w, "jnc %s|%s\n", jnc.regB, identString(jnc.addr))
 }
 
-func printAdd(add Add) {
-	fmt.Printf("add %d, %s\n", add.reg, add.value)
+func (tp *treePrinter) printComment(comment Comment) {
+	fmt.Fprintf(tp.w, "comment: %s\n", comment.text)
 }
 
-func printMov(mov Mov) {
-	fmt.Printf("mov %d, %d +%d\n", mov.reg1, mov.reg2, mov.fa)
+func (tp *treePrinter) printData(data Data) {
+	fmt.Fprintf(tp.w, "data: %s\n", dataString(data))
 }
 
-func printOut(out Out) {
-	fmt.Printf("out %d %s\n", out.reg, out.fa)
+func (tp *treePrinter) printReserve(res Reserve) {
+	fmt.Fprintf(tp.w, "reserve: %s\n", identString(res.size))
 }
 
-func printCmp(cmp Cmp) {
-	fmt.Printf("cmp %d, %d, %d\n", cmp.regA, cmp.regB, cmp.operation)
+func (tp *treePrinter) printNumber(number Number) {
+	fmt.Fprintf(tp.w, "number: %s\n", identString(number))
 }
 
-func printJmp(jmp Jmp) {
-	fmt.Printf("jmp %d|%d\n", jmp.regB, jmp.addr)
+func (tp *treePrinter) printSimpleString(str SimpleString) {
+	fmt.Fprintf(tp.w, "simple_string: %s\n", identString(str))
 }
 
-func printJnc(jnc Jnc) {
-	fmt.Printf("jnc %d|%d\n", jnc.regB, jnc.addr)
+func (tp *treePrinter) printLocation(loc Location) {
+	fmt.Fprintf(tp.w, "location: %s\n", identString(loc))
 }
@@ -0,0 +1,222 @@
+package libpreproc
+
+//Symbol - label with its location in memory
+type Symbol struct {
+	name    string
+	section string
+	offset  int
+	address int
+	pos     Pos
+}
+
+//Name returns label name
+func (s Symbol) Name() string {
+	return s.name
+}
+
+//Section returns name of section containing the label
+func (s Symbol) Section() string {
+	return s.section
+}
+
+//Offset returns label offset from the beginning of its section
+func (s Symbol) Offset() int {
+	return s.offset
+}
+
+//Address returns label address
+func (s Symbol) Address() int {
+	return s.address
+}
+
+//Pos returns position of label definition in source
+func (s Symbol) Pos() Pos {
+	return s.pos
+}
+
+//SymbolTable - labels of the program
+type SymbolTable map[string]Symbol
+
+//Value evaluates operand, labels are replaced by their addresses
+func (st SymbolTable) Value(id Ident) (int, error) {
+	return Eval(id, st.leaf)
+}
+
+func (st SymbolTable) leaf(id Ident) (int, error) {
+	if l, ok := id.(Label); ok {
+		name, err := definitionName(l)
+		if err != nil {
+			return 0, err
+		}
+		sym, found := st[name]
+		if !found {
+			return 0, errorAt(l.pos, "undefined label %q", name)
+		}
+		return sym.address, nil
+	}
+	return numberLeaf(id)
+}
+
+//ResolveLabels assigns addresses to all labels of preprocessed program and
+//turns label references into Label operands. Sections are placed one after
+//another in order of their first appearance; the linker relocates them later.
+func ResolveLabels(prog Program) (Program, SymbolTable, error) {
+	table, order, sizes, err := collectLabels(prog)
+	if err != nil {
+		return prog, nil, err
+	}
+	bases := make(map[string]int)
+	base := 0
+	for _, name := range order {
+		bases[name] = base
+		base += sizes[name]
+	}
+	table.relocate(bases)
+	out, err := table.resolveProgram(prog, bases)
+	if err != nil {
+		return prog, nil, err
+	}
+	return out, table, nil
+}
+
+//collectLabels is the first pass: it finds all labels with their section
+//offsets and computes sizes of sections listed in order of appearance
+func collectLabels(prog Program) (SymbolTable, []string, map[string]int, error) {
+	table := make(SymbolTable)
+	var order []string
+	sizes := make(map[string]int)
+	for _, section := range prog.sections {
+		name := section.sectionName
+		if _, seen := sizes[name]; !seen {
+			order = append(order, name)
+			sizes[name] = 0
+		}
+		for _, stmt := range section.sectionContent.elements {
+			if l, ok := stmt.(Label); ok {
+				labelName, err := definitionName(l)
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				if prev, dup := table[labelName]; dup {
+					return nil, nil, nil, errorAt(l.pos, "duplicate label %q, first defined at %s", labelName, prev.pos)
+				}
+				table[labelName] = Symbol{name: labelName, section: name, offset: sizes[name], pos: l.pos}
+			}
+			sizes[name] += stmtSize(stmt)
+		}
+	}
+	return table, order, sizes, nil
+}
+
+//resolveProgram is the second pass: it checks and resolves label references
+//and replaces location $ by address of the statement
+func (st SymbolTable) resolveProgram(prog Program, bases map[string]int) (Program, error) {
+	//settings of program and sections are kept, only statements change
+	out := prog
+	out.sections = nil
+	addrs := make(map[string]int)
+	for name, base := range bases {
+		addrs[name] = base
+	}
+	for _, section := range prog.sections {
+		var content Block
+		for _, stmt := range section.sectionContent.elements {
+			resolved, err := st.resolveStmt(stmt, addrs[section.sectionName])
+			if err != nil {
+				return prog, withPos(posOf(stmt), err)
+			}
+			content.elements = append(content.elements, resolved)
+			addrs[section.sectionName] += stmtSize(stmt)
+		}
+		section.sectionContent = content
+		out.sections = append(out.sections, section)
+	}
+	return out, nil
+}
+
+//relocate sets label addresses using base addresses of sections
+func (st SymbolTable) relocate(bases map[string]int) {
+	for name, sym := range st {
+		sym.address = bases[sym.section] + sym.offset
+		st[name] = sym
+	}
+}
+
+func (st SymbolTable) resolveStmt(stmt Stmt, addr int) (Stmt, error) {
+	var err error
+	switch v := stmt.(type) {
+	case Add:
+		v.value, err = st.resolveOperand(v.value, addr)
+		return v, err
+	case Mov:
+		v.fa, err = st.resolveOperand(v.fa, addr)
+		return v, err
+	case In:
+		v.fa, err = st.resolveOperand(v.fa, addr)
+		return v, err
+	case Out:
+		v.fa, err = st.resolveOperand(v.fa, addr)
+		return v, err
+	case Cmp:
+		v.operation, err = st.resolveOperand(v.operation, addr)
+		return v, err
+	case Jmp:
+		v.addr, err = st.resolveOperand(v.addr, addr)
+		return v, err
+	case Jnc:
+		v.addr, err = st.resolveOperand(v.addr, addr)
+		return v, err
+	case Data:
+		values := make([]Ident, len(v.values))
+		for i, value := range v.values {
+			if values[i], err = st.resolveOperand(value, addr); err != nil {
+				return v, err
+			}
+		}
+		v.values = values
+		return v, nil
+	}
+	return stmt, nil
+}
+
+//resolveOperand turns references to known labels into Label operands,
+//location $ is replaced by addr
+func (st SymbolTable) resolveOperand(id Ident, addr int) (Ident, error) {
+	var err error
+	switch v := id.(type) {
+	case Variable:
+		if _, found := st[v.name]; !found {
+			return id, errorAt(v.pos, "undefined label %q", v.name)
+		}
+		return Label{name: v, pos: v.pos}, nil
+	case Label:
+		name, err := definitionName(v)
+		if err != nil {
+			return id, err
+		}
+		if _, found := st[name]; !found {
+			return id, errorAt(v.pos, "undefined label %q", name)
+		}
+	case Location:
+		return Number{value: addr, pos: v.pos}, nil
+	case Unary:
+		v.x, err = st.resolveOperand(v.x, addr)
+		return v, err
+	case Binary:
+		if v.x, err = st.resolveOperand(v.x, addr); err != nil {
+			return v, err
+		}
+		v.y, err = st.resolveOperand(v.y, addr)
+		return v, err
+	case Call:
+		args := make([]Ident, len(v.args))
+		for i, arg := range v.args {
+			if args[i], err = st.resolveOperand(arg, addr); err != nil {
+				return v, err
+			}
+		}
+		v.args = args
+		return v, nil
+	}
+	return id, nil
+}
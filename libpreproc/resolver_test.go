@@ -0,0 +1,64 @@
+package libpreproc
+
+import "testing"
+
+//resolve parses, preprocesses and resolves labels of source
+func resolve(t *testing.T, src string) (Program, SymbolTable, error) {
+	t.Helper()
+	return ResolveLabels(processSource(t, src))
+}
+
+func TestResolveLabels(t *testing.T) {
+	src := `section .text
+    jmp end
+start:
+    add a, 1
+end:
+    jnc start
+section .data
+x: .byte 1
+section .text
+    mov a, x
+`
+	out, table, err := resolve(t, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//both parts of .text go before .data
+	wantAddr := map[string]int{"start": 1, "end": 2, "x": 4}
+	for name, addr := range wantAddr {
+		if sym, ok := table[name]; !ok || sym.Address() != addr {
+			t.Errorf("%s: got %+v, want address %d", name, sym, addr)
+		}
+	}
+	if sym := table["x"]; sym.Section() != ".data" || sym.Offset() != 0 {
+		t.Errorf("x: got section %s offset %d, want .data 0", sym.Section(), sym.Offset())
+	}
+	var code []byte
+	for _, section := range out.sections {
+		b, err := AssembleBlock(section.sectionContent, table.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		code = append(code, b...)
+	}
+	if want := []byte{0xF2, 0x01, 0xE1, 0x01, 0x34}; string(code) != string(want) {
+		t.Errorf("got % x, want % x", code, want)
+	}
+}
+
+func TestResolveLabelsErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"section .text\nl:\nl:\n", `3:1: duplicate label "l", first defined at 2:1`},
+		{"section .text\n    jmp nowhere\n", `2:9: undefined label "nowhere"`},
+	}
+	for _, tt := range tests {
+		_, _, err := resolve(t, tt.src)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%q: got error %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
@@ -3,9 +3,36 @@ package libpreproc
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"io"
+	"strings"
 )
 
+//Pos - position in source file
+type Pos struct {
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+//IsValid reports whether position is known
+func (pos Pos) IsValid() bool {
+	return pos.Line > 0
+}
+
+func (pos Pos) String() string {
+	if !pos.IsValid() {
+		if pos.File == "" {
+			return "-"
+		}
+		return pos.File
+	}
+	if pos.File == "" {
+		return fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", pos.File, pos.Line, pos.Column)
+}
+
 func isWhiteSpace(ch rune) bool {
 	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
 }
@@ -15,49 +42,93 @@ func isLetter(ch rune) bool {
 }
 
 func isDigit(ch rune) bool {
-	return (ch >= '0' && ch <= '9' || ch == '+' || ch == '-')
+	return ch >= '0' && ch <= '9'
 }
 
 var eof = rune(0)
 
 //Scanner - represents a lexical scanner/
 type Scanner struct {
-	r *bufio.Reader
+	r      *bufio.Reader
+	pos    Pos          //position of the next rune
+	prev   Pos          //position restored by unread
+	queued *queuedToken //token scanned ahead
+}
+
+//queuedToken - token scanned together with the previous one
+type queuedToken struct {
+	tok Token
+	lit string
+	pos Pos
 }
 
 //NewScanner - returns a new instance of Scanner
 func NewScanner(r io.Reader) *Scanner {
-	return &Scanner{r: bufio.NewReader(r)}
+	return NewFileScanner(r, "")
+}
+
+//NewFileScanner - returns a new instance of Scanner reporting positions in file
+func NewFileScanner(r io.Reader, filename string) *Scanner {
+	return &Scanner{r: bufio.NewReader(r), pos: Pos{File: filename, Line: 1, Column: 1}}
 }
 
 //Returns the rune(0) if error occurs (or io.EOF is returned)
 func (s *Scanner) read() rune {
+	s.prev = s.pos
 	ch, _, err := s.r.ReadRune()
 	if err != nil {
 		return eof
 	}
+	if ch == '\n' {
+		s.pos.Line++
+		s.pos.Column = 1
+	} else {
+		s.pos.Column++
+	}
 	return ch
 }
 
+//renumber shifts line numbers from the current position on by delta and
+//renames file unless file is empty, it implements #line
+func (s *Scanner) renumber(delta int, file string) {
+	s.pos.Line += delta
+	s.prev.Line += delta
+	if file != "" {
+		s.pos.File, s.prev.File = file, file
+	}
+}
+
 //unread places the previously read rune back on the reader
 func (s *Scanner) unread() {
 	_ = s.r.UnreadRune()
+	s.pos = s.prev
 }
 
-//Scan returns the next token and literal value
-func (s *Scanner) Scan() (tok Token, lit string) {
+//Scan returns the next token, literal value and position of the token
+func (s *Scanner) Scan() (tok Token, lit string, pos Pos) {
+	if q := s.queued; q != nil {
+		s.queued = nil
+		return q.tok, q.lit, q.pos
+	}
+	pos = s.pos
+	tok, lit = s.scan()
+	return tok, lit, pos
+}
+
+func (s *Scanner) scan() (tok Token, lit string) {
 	//Read the next rune.
 	ch := s.read()
 
 	if isWhiteSpace(ch) {
 		s.unread()
 		return s.scanWhitespace()
-	} else if isLetter(ch) {
-		s.unread()
-		return s.scanIdent()
+	} else if ch == '.' && s.follows('.') {
+		return s.scanDots()
+	} else if isLetter(ch) || ch == '_' {
+		//rune can't be unread after follows, so it is passed on
+		return s.scanIdent(ch)
 	} else if isDigit(ch) {
-		s.unread()
-		return s.scanIdent()
+		return s.scanIdent(ch)
 	}
 
 	switch ch {
@@ -66,15 +137,103 @@ func (s *Scanner) Scan() (tok Token, lit string) {
 	case ',':
 		return COMMA, string(ch)
 	case ';':
-		return SEMI, string(ch)
+		return s.scanLineComment(";")
+	case '/':
+		switch s.read() {
+		case '/':
+			return s.scanLineComment("//")
+		case '*':
+			return s.scanBlockComment()
+		}
+		s.unread()
+		return SLASH, string(ch)
 	case '"':
 		return QUOTE, string(ch)
 	case ':':
 		return COLON, string(ch)
+	case '(':
+		return LPAREN, string(ch)
+	case ')':
+		return RPAREN, string(ch)
+	case '+':
+		return PLUS, string(ch)
+	case '-':
+		return MINUS, string(ch)
+	case '*':
+		return STAR, string(ch)
+	case '%':
+		if s.follows('%') {
+			return s.scanLocal()
+		}
+		return PERCENT, string(ch)
+	case '@':
+		return s.scanAnonymous()
+	case '^':
+		return CARET, string(ch)
+	case '~':
+		return TILDE, string(ch)
+	case '&':
+		if s.follows('&') {
+			return LAND, "&&"
+		}
+		return AMP, string(ch)
+	case '|':
+		if s.follows('|') {
+			return LOR, "||"
+		}
+		return PIPE, string(ch)
+	case '!':
+		if s.follows('=') {
+			return NEQ, "!="
+		}
+		return NOT, string(ch)
+	case '=':
+		if s.follows('=') {
+			return EQL, "=="
+		}
+		return ASSIGN, string(ch)
+	case '<':
+		if s.follows('<') {
+			return SHL, "<<"
+		} else if s.follows('=') {
+			return LEQ, "<="
+		}
+		return LSS, string(ch)
+	case '>':
+		if s.follows('>') {
+			return SHR, ">>"
+		} else if s.follows('=') {
+			return GEQ, ">="
+		}
+		return GTR, string(ch)
 	}
 	return ILLEGAL, string(ch)
 }
 
+//scanDots returns .. or ..., two dots are already read
+func (s *Scanner) scanDots() (tok Token, lit string) {
+	if s.follows('.') {
+		return ELLIPSIS, "..."
+	}
+	return RANGE, ".."
+}
+
+//queueDots scans dots met at the end of identifier, they are returned by
+//the next Scan. pos is position of the first dot
+func (s *Scanner) queueDots(pos Pos) {
+	tok, lit := s.scanDots()
+	s.queued = &queuedToken{tok: tok, lit: lit, pos: pos}
+}
+
+//follows consumes the next rune if it is ch
+func (s *Scanner) follows(ch rune) bool {
+	if s.read() == ch {
+		return true
+	}
+	s.unread()
+	return false
+}
+
 // scanWhitespace consumes the current rune and all contiguous whitespace.
 func (s *Scanner) scanWhitespace() (tok Token, lit string) {
 	//Create a buffer and read the current character into it
@@ -96,17 +255,117 @@ func (s *Scanner) scanWhitespace() (tok Token, lit string) {
 	return WS, buf.String()
 }
 
-// scanIdent consumes the current rune and all contiguous whitespace.
-func (s *Scanner) scanIdent() (tok Token, lit string) {
+//scanLineComment consumes comment up to the end of line, opening
+//delimiter is already read. The newline is left for whitespace token
+func (s *Scanner) scanLineComment(delim string) (tok Token, lit string) {
 	var buf bytes.Buffer
-	buf.WriteRune(s.read())
+	buf.WriteString(delim)
+	for {
+		if ch := s.read(); ch == eof {
+			break
+		} else if ch == '\n' {
+			s.unread()
+			break
+		} else {
+			buf.WriteRune(ch)
+		}
+	}
+	return COMMENT, strings.TrimRight(buf.String(), "\r")
+}
 
+//scanBlockComment consumes comment up to */, opening /* is already read.
+//Unterminated comment is returned as ILLEGAL token
+func (s *Scanner) scanBlockComment() (tok Token, lit string) {
+	var buf bytes.Buffer
+	buf.WriteString("/*")
+	for {
+		ch := s.read()
+		if ch == eof {
+			return ILLEGAL, buf.String()
+		}
+		buf.WriteRune(ch)
+		if ch == '*' {
+			if next := s.read(); next == '/' {
+				buf.WriteRune(next)
+				return COMMENT, buf.String()
+			}
+			s.unread()
+		}
+	}
+}
+
+//scanString consumes string up to closing quote, opening quote is
+//already read. ok is false if string is not terminated
+func (s *Scanner) scanString() (str string, ok bool) {
+	var buf bytes.Buffer
+	for {
+		ch := s.read()
+		if ch == eof {
+			return buf.String(), false
+		}
+		if ch == '"' {
+			return buf.String(), true
+		}
+		buf.WriteRune(ch)
+	}
+}
+
+//scanLocal consumes macro-local label %%name, %% is already read
+func (s *Scanner) scanLocal() (tok Token, lit string) {
+	var buf bytes.Buffer
+	buf.WriteString(localPrefix)
 	for {
 		if ch := s.read(); ch == eof {
 			break
 		} else if !isLetter(ch) && !isDigit(ch) && ch != '_' {
 			s.unread()
 			break
+		} else {
+			buf.WriteRune(ch)
+		}
+	}
+	if buf.Len() == len(localPrefix) {
+		return ILLEGAL, buf.String()
+	}
+	return IDENT, buf.String()
+}
+
+//scanAnonymous consumes anonymous label @@ or reference @f, @b to it,
+//@ is already read
+func (s *Scanner) scanAnonymous() (tok Token, lit string) {
+	ch := s.read()
+	if ch == eof {
+		return ILLEGAL, "@"
+	}
+	lit = strings.ToLower("@" + string(ch))
+	if next := s.read(); next != eof {
+		s.unread()
+		if isLetter(next) || isDigit(next) || next == '_' {
+			return ILLEGAL, lit
+		}
+	}
+	switch lit {
+	case anonLabel, anonForward, anonBackward:
+		return IDENT, lit
+	}
+	return ILLEGAL, lit
+}
+
+// scanIdent consumes identifier, its first rune is already read.
+func (s *Scanner) scanIdent(first rune) (tok Token, lit string) {
+	var buf bytes.Buffer
+	buf.WriteRune(first)
+
+	for {
+		if ch := s.read(); ch == eof {
+			break
+		} else if !isLetter(ch) && !isDigit(ch) && ch != '_' {
+			s.unread()
+			break
+		} else if dot := s.prev; ch == '.' && s.follows('.') {
+			//.. ends identifier, 0..15 is a range
+			s.queueDots(dot)
+			break
 		} else {
 			_, _ = buf.WriteRune(ch)
 		}
@@ -125,8 +384,8 @@ func (s *Scanner) scanIdent() (tok Token, lit string) {
 		return ERROR, buf.String()
 	case "#pragma":
 		return PRAGMA, buf.String()
-	// case "#line":
-	// 	return LINE, buf.String()
+	case "#line":
+		return LINE, buf.String()
 	case "#warn":
 		return WARN, buf.String()
 	case "#ifdef":
@@ -137,6 +396,10 @@ func (s *Scanner) scanIdent() (tok Token, lit string) {
 		return ENDIF, buf.String()
 	case "#else":
 		return ELSE, buf.String()
+	case "#if":
+		return IF, buf.String()
+	case "#elif":
+		return ELIF, buf.String()
 	case "#sumdef":
 		return SUMDEF, buf.String()
 	case "#resdef":
@@ -149,6 +412,26 @@ func (s *Scanner) scanIdent() (tok Token, lit string) {
 		return MACRO, buf.String()
 	case "#endmacro":
 		return ENDMACRO, buf.String()
+	case "#for":
+		return FOR, buf.String()
+	case "#endfor":
+		return ENDFOR, buf.String()
+	case "#rept":
+		return REPT, buf.String()
+	case "#endrept":
+		return ENDREPT, buf.String()
+	case "#while":
+		return WHILE, buf.String()
+	case "#endwhile":
+		return ENDWHILE, buf.String()
+	case ".nibble":
+		return NIBBLE, buf.String()
+	case ".byte":
+		return BYTE, buf.String()
+	case ".string":
+		return STRING, buf.String()
+	case ".space":
+		return SPACE, buf.String()
 	case "add":
 		return ADD, buf.String()
 	case "mov":
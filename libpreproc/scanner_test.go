@@ -0,0 +1,76 @@
+package libpreproc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScanPositions(t *testing.T) {
+	s := NewFileScanner(strings.NewReader("add a, 2\n  jmp x ; c\n\t\"s\"\n"), "f.s")
+	want := []struct {
+		tok       Token
+		lit       string
+		line, col int
+	}{
+		{ADD, "add", 1, 1},
+		{A, "a", 1, 5},
+		{COMMA, ",", 1, 6},
+		{IDENT, "2", 1, 8},
+		{JMP, "jmp", 2, 3},
+		{IDENT, "x", 2, 7},
+		{COMMENT, "; c", 2, 9},
+		{QUOTE, "\"", 3, 2},
+		{IDENT, "s", 3, 3},
+		{QUOTE, "\"", 3, 4},
+		{EOF, "", 4, 1},
+	}
+	for _, w := range want {
+		tok, lit, pos := s.Scan()
+		for tok == WS {
+			tok, lit, pos = s.Scan()
+		}
+		if tok != w.tok || lit != w.lit || pos != (Pos{File: "f.s", Line: w.line, Column: w.col}) {
+			t.Errorf("got %v %q %s, want %v %q f.s:%d:%d", tok, lit, pos, w.tok, w.lit, w.line, w.col)
+		}
+	}
+}
+
+func TestNodePositions(t *testing.T) {
+	prog, err := NewFileParser(strings.NewReader("section .text\n    add a, 1\nl:  jmp l\n"), "f.s").ParseFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"f.s:2:5", "f.s:3:1", "f.s:3:5"}
+	stmts := prog.sections[0].sectionContent.elements
+	if len(stmts) != len(want) {
+		t.Fatalf("got %d statements, want %d", len(stmts), len(want))
+	}
+	for i, stmt := range stmts {
+		if got := stmt.Pos().String(); got != want[i] {
+			t.Errorf("%T: got %s, want %s", stmt, got, want[i])
+		}
+	}
+	if got := prog.sections[0].pos.String(); got != "f.s:1:1" {
+		t.Errorf("section: got %s, want f.s:1:1", got)
+	}
+	if got := stmts[0].(Add).value.Pos().String(); got != "f.s:2:12" {
+		t.Errorf("operand: got %s, want f.s:2:12", got)
+	}
+}
+
+func TestPosString(t *testing.T) {
+	tests := []struct {
+		pos  Pos
+		want string
+	}{
+		{Pos{}, "-"},
+		{Pos{File: "f.s"}, "f.s"},
+		{Pos{Line: 2, Column: 3}, "2:3"},
+		{Pos{File: "f.s", Line: 2, Column: 3}, "f.s:2:3"},
+	}
+	for _, tt := range tests {
+		if got := tt.pos.String(); got != tt.want {
+			t.Errorf("%#v: got %q, want %q", tt.pos, got, tt.want)
+		}
+	}
+}
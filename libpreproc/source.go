@@ -0,0 +1,144 @@
+package libpreproc
+
+import (
+	"fmt"
+	"strings"
+)
+
+//opcodeString returns opcode in assembler syntax
+func opcodeString(op Opcode) string {
+	switch v := op.(type) {
+	case Add:
+		return fmt.Sprintf("add %s, %s", v.reg, identString(v.value))
+	case Mov:
+		if v.reg2 == nr {
+			return fmt.Sprintf("mov %s, %s", v.reg1, identString(v.fa))
+		}
+		return "mov " + v.reg1.String() + ", " + v.reg2.String() + fastAddString(v.fa)
+	case In:
+		return "in " + v.reg.String() + fastAddString(v.fa)
+	case Out:
+		if v.reg == nr {
+			return fmt.Sprintf("out %s", identString(v.fa))
+		}
+		return "out " + v.reg.String() + fastAddString(v.fa)
+	case Cmp:
+		return fmt.Sprintf("cmp %s, %s, %s", v.regA, v.regB, identString(v.operation))
+	case Jmp:
+		if v.regB != nr {
+			return fmt.Sprintf("jmp %s", v.regB)
+		}
+		return fmt.Sprintf("jmp %s", identString(v.addr))
+	case Jnc:
+		if v.regB != nr {
+			return fmt.Sprintf("jnc %s", v.regB)
+		}
+		return fmt.Sprintf("jnc %s", identString(v.addr))
+	}
+	return fmt.Sprint(op)
+}
+
+//dataString returns data definition in assembler syntax
+func dataString(data Data) string {
+	str := dataMnemonic(data.kind)
+	for i, value := range data.values {
+		if i != 0 {
+			str += ","
+		}
+		str += " " + identString(value)
+	}
+	return str
+}
+
+func dataMnemonic(kind Token) string {
+	switch kind {
+	case NIBBLE:
+		return ".nibble"
+	case STRING:
+		return ".string"
+	}
+	return ".byte"
+}
+
+func fastAddString(fa Ident) string {
+	if fa == nil {
+		return ""
+	}
+	return ", " + identString(fa)
+}
+
+//identString returns identifier in assembler syntax
+func identString(id Ident) string {
+	switch v := id.(type) {
+	case nil:
+		return ""
+	case Number:
+		if v.lit != "" {
+			return v.lit
+		}
+		return fmt.Sprint(v.value)
+	case Variable:
+		return v.name
+	case Label:
+		return identString(v.name)
+	case SimpleString:
+		return `"` + v.value + `"`
+	case MacroCall:
+		//operands use call expression syntax
+		str := v.macroName + "("
+		for i, arg := range v.args {
+			if i != 0 {
+				str += ", "
+			}
+			str += identString(arg)
+		}
+		return str + ")"
+	case Location:
+		return "$"
+	case Unary:
+		return v.op.String() + operandString(v.x, unaryPrec)
+	case Binary:
+		prec := v.op.Precedence()
+		if v.op == RANGE {
+			return operandString(v.x, prec) + v.op.String() + operandString(v.y, prec)
+		}
+		return operandString(v.x, prec) + " " + v.op.String() + " " + operandString(v.y, prec+1)
+	case Call:
+		str := v.function + "("
+		for i, arg := range v.args {
+			if i != 0 {
+				str += ", "
+			}
+			str += identString(arg)
+		}
+		return str + ")"
+	}
+	return fmt.Sprint(id)
+}
+
+//operandString returns operand of expression, parenthesized if its
+//operator binds weaker than prec
+func operandString(id Ident, prec int) string {
+	if bin, ok := id.(Binary); ok && bin.op.Precedence() < prec {
+		return "(" + identString(id) + ")"
+	}
+	if num, ok := id.(Number); ok && num.value < 0 && prec == unaryPrec {
+		return "(" + identString(id) + ")"
+	}
+	return identString(id)
+}
+
+//paramsString returns macro parameters as in source
+func paramsString(macro Macro) string {
+	params := make([]string, len(macro.args))
+	for i, param := range macro.args {
+		params[i] = param
+		if i < len(macro.defaults) && macro.defaults[i] != nil {
+			params[i] += " = " + identString(macro.defaults[i])
+		}
+	}
+	if macro.variadic && len(params) != 0 {
+		params[len(params)-1] += variadicSuffix
+	}
+	return strings.Join(params, ", ")
+}
@@ -10,41 +10,100 @@ const (
 	pc Reg = 2
 )
 
-//Ident - identifier
+//Registers
+const (
+	RegNone = nr
+	RegA    = a
+	RegB    = b
+	RegPC   = pc
+)
+
+func (r Reg) String() string {
+	switch r {
+	case a:
+		return "a"
+	case b:
+		return "b"
+	case pc:
+		return "pc"
+	}
+	return "none"
+}
+
+//Ident - identifier or constant expression
 type Ident interface {
+	Node
+	identNode()
 }
 
 //SimpleString - specified identifier containing string
 // e.g. "Hello, World"
 type SimpleString struct {
 	value string
+	pos   Pos
 }
 
 //Number - specified identifier contatinig number
 // e.g. 42
 type Number struct {
 	value int
+	//lit keeps source spelling, e.g. 0x2A, empty for computed numbers
+	lit string
+	pos Pos
 }
 
 //Variable - specified identifier contatining preprocessor
 // value e.g. A
 type Variable struct {
 	name string
+	pos  Pos
+}
+
+//Binary - binary expression e.g. table + 3
+type Binary struct {
+	op  Token
+	x   Ident
+	y   Ident
+	pos Pos
+}
+
+//Unary - unary expression e.g. ~mask
+type Unary struct {
+	op  Token
+	x   Ident
+	pos Pos
+}
+
+//Call - function call e.g. hi(table)
+type Call struct {
+	function string
+	args     []Ident
+	pos      Pos
+}
+
+//Location - current location in memory, $
+type Location struct {
+	pos Pos
 }
 
 //Program - program object
 type Program struct {
 	sections []Section
+	arch     string //architecture required by #pragma arch
 }
 
-//Stmt - program statement (Section/Directive/Opcode/Label)
+//Stmt - program statement (Directive/Opcode/Label/Comment)
 type Stmt interface {
+	Node
+	stmtNode()
 }
 
 //Section - program section
 type Section struct {
 	sectionName    string
 	sectionContent Block
+	align          int //alignment set by #pragma section-align
+	pos            Pos
 }
 
 //Block - program block
@@ -58,50 +117,66 @@ type Block struct {
 type Define struct {
 	name       Ident
 	definition Ident
+	pos        Pos
 }
 
 //Import - #import
 type Import struct {
 	name Ident
+	pos  Pos
 }
 
-// //Line - #line
-// type Line struct {
-// 	name       Ident
-// 	lineNumber Ident
-// }
+//Line - #line, number of the following line and optional file name
+type Line struct {
+	name       Ident
+	lineNumber Ident
+	pos        Pos
+}
+
+//Pragma - #pragma, name and arguments are words of the rest of line
+type Pragma struct {
+	name string
+	args []string
+	pos  Pos
+}
 
 //Warn - #warn
 type Warn struct {
 	message Ident
+	pos     Pos
 }
 
 //Sumdef - #sumdef {
 type Sumdef struct {
 	def1 Ident
 	def2 Ident
+	pos  Pos
 }
 
 //Resdef - #resdef
 type Resdef struct {
 	def1 Ident
 	def2 Ident
+	pos  Pos
 }
 
 //Pext - #pext
 type Pext struct {
 	pextName    Ident
 	pextAddress Ident
+	pos         Pos
 }
 
 //Error - #error
 type Error struct {
 	message Ident
+	pos     Pos
 }
 
 //Undef - #undef
 type Undef struct {
 	definition Ident
+	pos        Pos
 }
 
 //Ifdef - #ifdef
@@ -109,6 +184,17 @@ type Ifdef struct {
 	definition Ident
 	bodyTrue   Block
 	bodyFalse  Block
+	pos        Pos
+}
+
+//If - #if, #elif is kept as If with elif set, being the only
+//statement of bodyFalse of the preceding branch
+type If struct {
+	condition Ident
+	bodyTrue  Block
+	bodyFalse Block
+	elif      bool
+	pos       Pos
 }
 
 //Ifndef - #ifdef
@@ -116,30 +202,62 @@ type Ifndef struct {
 	definition Ident
 	bodyTrue   Block
 	bodyFalse  Block
+	pos        Pos
 }
 
 //Macro - #macro
 type Macro struct {
 	macroName string
 	args      []string
+	defaults  []Ident //default values of parameters, nil if none has one
+	variadic  bool    //the last parameter takes the rest of arguments
+	comment   string  //trailing comment of #macro line
+	body      Block
+	pos       Pos
+}
+
+//For - #for
+type For struct {
+	variable Ident
+	values   []Ident
+	body     Block
+	pos      Pos
+}
+
+//Rept - #rept
+type Rept struct {
+	count    Ident
+	variable Ident
+	body     Block
+	pos      Pos
+}
+
+//While - #while
+type While struct {
+	condition Ident
 	body      Block
+	pos       Pos
 }
 
 //Return - #return
 type Return struct {
 	returnValue Ident
+	pos         Pos
 }
 
 //Opcodes
 
 //Opcode - opcode interface
 type Opcode interface {
+	Stmt
+	opcodeNode()
 }
 
 //Add - add
 type Add struct {
 	reg   Reg
 	value Ident
+	pos   Pos
 }
 
 //Mov - mov
@@ -147,17 +265,21 @@ type Mov struct {
 	reg1 Reg
 	reg2 Reg
 	fa   Ident
+	pos  Pos
 }
 
 //In - in
 type In struct {
 	reg Reg
+	fa  Ident
+	pos Pos
 }
 
 //Out - out
 type Out struct {
 	reg Reg
 	fa  Ident
+	pos Pos
 }
 
 //Cmp - cmp
@@ -165,27 +287,668 @@ type Cmp struct {
 	regA      Reg
 	regB      Reg
 	operation Ident
+	pos       Pos
 }
 
 //Jmp - jmp
 type Jmp struct {
 	regB Reg
 	addr Ident
+	pos  Pos
 }
 
 //Jnc - jnc
 type Jnc struct {
 	regB Reg
 	addr Ident
+	pos  Pos
 }
 
 //MacroCall - macro call
 type MacroCall struct {
 	macroName string
 	args      []Ident
+	pos       Pos
 }
 
 //Label - label
 type Label struct {
 	name Ident
+	pos  Pos
+}
+
+//Data - data definition: .nibble or .byte values, .string text
+//with terminating zero. Every value takes one memory cell
+type Data struct {
+	kind   Token
+	values []Ident
+	pos    Pos
+}
+
+//Reserve - .space, reserves memory cells filled with zeros
+type Reserve struct {
+	size Ident
+	pos  Pos
+}
+
+//Comment - line comment (; or //) or block comment (/* */),
+//text keeps comment delimiters. Trailing comment follows code
+//on the same line
+type Comment struct {
+	text     string
+	trailing bool
+	pos      Pos
+}
+
+//Pos returns position of simplestring in source
+func (v SimpleString) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of number in source
+func (v Number) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of variable in source
+func (v Variable) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of binary expression in source
+func (v Binary) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of unary expression in source
+func (v Unary) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of call in source
+func (v Call) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of location in source
+func (v Location) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of the first section
+func (v Program) Pos() Pos {
+	if len(v.sections) == 0 {
+		return Pos{}
+	}
+	return v.sections[0].pos
+}
+
+//Pos returns position of the first statement of block
+func (v Block) Pos() Pos {
+	if len(v.elements) == 0 {
+		return Pos{}
+	}
+	return v.elements[0].Pos()
+}
+
+//Pos returns position of section in source
+func (v Section) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of define in source
+func (v Define) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of import in source
+func (v Import) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of line in source
+func (v Line) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of pragma in source
+func (v Pragma) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of warn in source
+func (v Warn) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of sumdef in source
+func (v Sumdef) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of resdef in source
+func (v Resdef) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of pext in source
+func (v Pext) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of error in source
+func (v Error) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of undef in source
+func (v Undef) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of ifdef in source
+func (v Ifdef) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of if in source
+func (v If) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of ifndef in source
+func (v Ifndef) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of macro in source
+func (v Macro) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of for in source
+func (v For) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of rept in source
+func (v Rept) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of while in source
+func (v While) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of return in source
+func (v Return) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of add in source
+func (v Add) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of mov in source
+func (v Mov) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of in in source
+func (v In) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of out in source
+func (v Out) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of cmp in source
+func (v Cmp) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of jmp in source
+func (v Jmp) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of jnc in source
+func (v Jnc) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of macro call in source
+func (v MacroCall) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of label in source
+func (v Label) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of data in source
+func (v Data) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of reserve in source
+func (v Reserve) Pos() Pos {
+	return v.pos
+}
+
+//Pos returns position of comment in source
+func (v Comment) Pos() Pos {
+	return v.pos
+}
+
+//Value returns string value without quotes
+func (v SimpleString) Value() string {
+	return v.value
+}
+
+//Value returns value of number
+func (v Number) Value() int {
+	return v.value
+}
+
+//Literal returns source spelling of number, empty if it was computed
+func (v Number) Literal() string {
+	return v.lit
+}
+
+//Name returns name of variable
+func (v Variable) Name() string {
+	return v.name
+}
+
+//Op returns operator
+func (v Binary) Op() Token {
+	return v.op
+}
+
+//X returns left operand
+func (v Binary) X() Ident {
+	return v.x
+}
+
+//Y returns right operand
+func (v Binary) Y() Ident {
+	return v.y
+}
+
+//Op returns operator
+func (v Unary) Op() Token {
+	return v.op
+}
+
+//X returns operand
+func (v Unary) X() Ident {
+	return v.x
+}
+
+//Function returns name of called function
+func (v Call) Function() string {
+	return v.function
+}
+
+//Args returns arguments
+func (v Call) Args() []Ident {
+	return v.args
+}
+
+//Sections returns sections in source order
+func (v Program) Sections() []Section {
+	return v.sections
+}
+
+//Arch returns architecture required by #pragma arch, empty if any
+func (v Program) Arch() string {
+	return v.arch
+}
+
+//Name returns name of section, empty for statements preceding the first section
+func (v Section) Name() string {
+	return v.sectionName
+}
+
+//Body returns statements of section
+func (v Section) Body() Block {
+	return v.sectionContent
+}
+
+//Align returns alignment of section start set by #pragma section-align,
+//0 if it is not aligned
+func (v Section) Align() int {
+	return v.align
+}
+
+//Stmts returns statements of block
+func (v Block) Stmts() []Stmt {
+	return v.elements
+}
+
+//Number returns number of the line following directive
+func (v Line) Number() Ident {
+	return v.lineNumber
+}
+
+//File returns reported file name, nil if it is kept
+func (v Line) File() Ident {
+	return v.name
+}
+
+//Name returns pragma name
+func (v Pragma) Name() string {
+	return v.name
+}
+
+//Args returns pragma arguments
+func (v Pragma) Args() []string {
+	return v.args
+}
+
+//Name returns defined name
+func (v Define) Name() Ident {
+	return v.name
+}
+
+//Value returns value of definition, nil if it is omitted
+func (v Define) Value() Ident {
+	return v.definition
+}
+
+//Path returns imported file name
+func (v Import) Path() Ident {
+	return v.name
+}
+
+//Message returns warning message
+func (v Warn) Message() Ident {
+	return v.message
+}
+
+//Name returns name which gets the sum
+func (v Sumdef) Name() Ident {
+	return v.def1
+}
+
+//Operand returns added value
+func (v Sumdef) Operand() Ident {
+	return v.def2
+}
+
+//Name returns name which gets the difference
+func (v Resdef) Name() Ident {
+	return v.def1
+}
+
+//Operand returns subtracted value
+func (v Resdef) Operand() Ident {
+	return v.def2
+}
+
+//Name returns port name
+func (v Pext) Name() Ident {
+	return v.pextName
+}
+
+//Address returns port address
+func (v Pext) Address() Ident {
+	return v.pextAddress
+}
+
+//Message returns error message
+func (v Error) Message() Ident {
+	return v.message
+}
+
+//Name returns undefined name
+func (v Undef) Name() Ident {
+	return v.definition
+}
+
+//Name returns tested name
+func (v Ifdef) Name() Ident {
+	return v.definition
+}
+
+//Then returns block used if name is defined
+func (v Ifdef) Then() Block {
+	return v.bodyTrue
+}
+
+//Else returns block used otherwise
+func (v Ifdef) Else() Block {
+	return v.bodyFalse
+}
+
+//Cond returns condition expression
+func (v If) Cond() Ident {
+	return v.condition
+}
+
+//Then returns block used if condition is not zero
+func (v If) Then() Block {
+	return v.bodyTrue
+}
+
+//Else returns block used otherwise
+func (v If) Else() Block {
+	return v.bodyFalse
+}
+
+//IsElif returns true for #elif, which is the only statement of Else of enclosing directive
+func (v If) IsElif() bool {
+	return v.elif
+}
+
+//Name returns tested name
+func (v Ifndef) Name() Ident {
+	return v.definition
+}
+
+//Then returns block used if name is not defined
+func (v Ifndef) Then() Block {
+	return v.bodyTrue
+}
+
+//Else returns block used otherwise
+func (v Ifndef) Else() Block {
+	return v.bodyFalse
+}
+
+//Name returns macro name
+func (v Macro) Name() string {
+	return v.macroName
+}
+
+//Params returns names of parameters
+func (v Macro) Params() []string {
+	return v.args
+}
+
+//Defaults returns default values of parameters, nil for parameters
+//without one
+func (v Macro) Defaults() []Ident {
+	return v.defaults
+}
+
+//Variadic reports whether the last parameter takes the rest of arguments
+func (v Macro) Variadic() bool {
+	return v.variadic
+}
+
+//Body returns macro body
+func (v Macro) Body() Block {
+	return v.body
+}
+
+//Var returns loop variable
+func (v For) Var() Ident {
+	return v.variable
+}
+
+//Values returns values taken by loop variable
+func (v For) Values() []Ident {
+	return v.values
+}
+
+//Body returns loop body
+func (v For) Body() Block {
+	return v.body
+}
+
+//Count returns number of repetitions
+func (v Rept) Count() Ident {
+	return v.count
+}
+
+//Var returns counter variable, nil if there is none
+func (v Rept) Var() Ident {
+	return v.variable
+}
+
+//Body returns repeated block
+func (v Rept) Body() Block {
+	return v.body
+}
+
+//Cond returns loop condition
+func (v While) Cond() Ident {
+	return v.condition
+}
+
+//Body returns loop body
+func (v While) Body() Block {
+	return v.body
+}
+
+//Value returns returned value
+func (v Return) Value() Ident {
+	return v.returnValue
+}
+
+//Reg returns destination register
+func (v Add) Reg() Reg {
+	return v.reg
+}
+
+//Value returns added immediate
+func (v Add) Value() Ident {
+	return v.value
+}
+
+//Dst returns destination register
+func (v Mov) Dst() Reg {
+	return v.reg1
+}
+
+//Src returns source register, RegNone for immediate
+func (v Mov) Src() Reg {
+	return v.reg2
+}
+
+//Value returns immediate or value added to source register, may be nil
+func (v Mov) Value() Ident {
+	return v.fa
+}
+
+//Reg returns destination register
+func (v In) Reg() Reg {
+	return v.reg
+}
+
+//Value returns value added to input, may be nil
+func (v In) Value() Ident {
+	return v.fa
+}
+
+//Reg returns source register, RegNone for immediate
+func (v Out) Reg() Reg {
+	return v.reg
+}
+
+//Value returns immediate or value added to register, may be nil
+func (v Out) Value() Ident {
+	return v.fa
+}
+
+//RegA returns first compared register
+func (v Cmp) RegA() Reg {
+	return v.regA
+}
+
+//RegB returns second compared register
+func (v Cmp) RegB() Reg {
+	return v.regB
+}
+
+//Operation returns comparison operation
+func (v Cmp) Operation() Ident {
+	return v.operation
+}
+
+//Reg returns register holding address, RegNone for immediate
+func (v Jmp) Reg() Reg {
+	return v.regB
+}
+
+//Addr returns jump address, nil for register jump
+func (v Jmp) Addr() Ident {
+	return v.addr
+}
+
+//Reg returns register holding address, RegNone for immediate
+func (v Jnc) Reg() Reg {
+	return v.regB
+}
+
+//Addr returns jump address, nil for register jump
+func (v Jnc) Addr() Ident {
+	return v.addr
+}
+
+//Name returns called macro
+func (v MacroCall) Name() string {
+	return v.macroName
+}
+
+//Args returns arguments
+func (v MacroCall) Args() []Ident {
+	return v.args
+}
+
+//Name returns label name
+func (v Label) Name() Ident {
+	return v.name
+}
+
+//Type returns NIBBLE, BYTE or STRING
+func (v Data) Type() Token {
+	return v.kind
+}
+
+//Values returns defined values
+func (v Data) Values() []Ident {
+	return v.values
+}
+
+//Size returns number of reserved cells
+func (v Reserve) Size() Ident {
+	return v.size
+}
+
+//Text returns comment text with delimiters
+func (v Comment) Text() string {
+	return v.text
+}
+
+//Trailing returns true if comment follows code on the same line
+func (v Comment) Trailing() bool {
+	return v.trailing
 }
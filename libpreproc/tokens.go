@@ -1,5 +1,7 @@
 package libpreproc
 
+import "fmt"
+
 //Token represents a lexical token
 type Token int
 
@@ -19,15 +21,68 @@ const (
 	IDENT
 	//QUOTE - "
 	QUOTE
+	//COMMENT - ; or // line comment, /* */ block comment
+	COMMENT
 
 	//Misc characters
 
 	//COMMA - ,
 	COMMA
-	//SEMI - ;
-	SEMI
 	//COLON - :
 	COLON
+	//LPAREN - (
+	LPAREN
+	//RPAREN - )
+	RPAREN
+
+	//Operators
+
+	//PLUS - +
+	PLUS
+	//MINUS - -
+	MINUS
+	//STAR - *
+	STAR
+	//SLASH - /
+	SLASH
+	//PERCENT - %
+	PERCENT
+	//AMP - &
+	AMP
+	//PIPE - |
+	PIPE
+	//CARET - ^
+	CARET
+	//TILDE - ~
+	TILDE
+	//SHL - <<
+	SHL
+	//SHR - >>
+	SHR
+	//NOT - !
+	NOT
+	//LAND - &&
+	LAND
+	//LOR - ||
+	LOR
+	//EQL - ==
+	EQL
+	//NEQ - !=
+	NEQ
+	//LSS - <
+	LSS
+	//LEQ - <=
+	LEQ
+	//GTR - >
+	GTR
+	//GEQ - >=
+	GEQ
+	//ASSIGN - =
+	ASSIGN
+	//RANGE - ..
+	RANGE
+	//ELLIPSIS - ...
+	ELLIPSIS
 
 	//Keywords
 
@@ -47,8 +102,8 @@ const (
 	ERROR
 	//PRAGMA - #pragma
 	PRAGMA
-	// //LINE - #line
-	// LINE
+	//LINE - #line
+	LINE
 
 	//WARN - #warn
 	WARN
@@ -60,6 +115,10 @@ const (
 	ENDIF
 	//ELSE - #else
 	ELSE
+	//IF - #if
+	IF
+	//ELIF - #elif
+	ELIF
 	//SUMDEF - #sumdef
 	SUMDEF
 	//RESDEF - #resdef
@@ -72,6 +131,29 @@ const (
 	MACRO
 	//ENDMACRO - #endmacro
 	ENDMACRO
+	//FOR - #for
+	FOR
+	//ENDFOR - #endfor
+	ENDFOR
+	//REPT - #rept
+	REPT
+	//ENDREPT - #endrept
+	ENDREPT
+	//WHILE - #while
+	WHILE
+	//ENDWHILE - #endwhile
+	ENDWHILE
+
+	/*Data keywords*/
+
+	//NIBBLE - e.g. .nibble 1, 2
+	NIBBLE
+	//BYTE - e.g. .byte 200
+	BYTE
+	//STRING - e.g. .string "Hello"
+	STRING
+	//SPACE - e.g. .space 4
+	SPACE
 
 	/*Assembler keywords*/
 
@@ -101,3 +183,67 @@ const (
 	//NR - no reg
 	NR
 )
+
+var operators = map[Token]string{
+	PLUS:    "+",
+	MINUS:   "-",
+	STAR:    "*",
+	SLASH:   "/",
+	PERCENT: "%",
+	AMP:     "&",
+	PIPE:    "|",
+	CARET:   "^",
+	TILDE:   "~",
+	SHL:     "<<",
+	SHR:     ">>",
+	NOT:     "!",
+	LAND:    "&&",
+	LOR:     "||",
+	EQL:     "==",
+	NEQ:     "!=",
+	LSS:     "<",
+	LEQ:     "<=",
+	GTR:     ">",
+	GEQ:     ">=",
+	RANGE:   "..",
+}
+
+//Precedence levels of binary operators, non-operators have lowestPrec
+const (
+	lowestPrec = 0
+	unaryPrec  = 11
+)
+
+//Precedence returns precedence of binary operator
+func (tok Token) Precedence() int {
+	switch tok {
+	case LOR:
+		return 1
+	case LAND:
+		return 2
+	case PIPE:
+		return 3
+	case CARET:
+		return 4
+	case AMP:
+		return 5
+	case EQL, NEQ:
+		return 6
+	case LSS, LEQ, GTR, GEQ:
+		return 7
+	case SHL, SHR:
+		return 8
+	case PLUS, MINUS:
+		return 9
+	case STAR, SLASH, PERCENT:
+		return 10
+	}
+	return lowestPrec
+}
+
+func (tok Token) String() string {
+	if op, ok := operators[tok]; ok {
+		return op
+	}
+	return fmt.Sprintf("token(%d)", int(tok))
+}
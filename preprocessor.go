@@ -1,26 +1,466 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"strings"
+
 	p "preprocessor/libpreproc"
 )
 
+//Exit codes
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+//command - CLI subcommand
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) int
+}
+
+var commands = []command{
+	{"parse", "parse [-json] file.s - print AST of source file, -json dumps it as JSON", runParse},
+	{"fmt", "fmt [-w] file.s ... - format source files", runFmt},
+	{"preprocess", "preprocess [flags] file.s - print preprocessed source", runPreprocess},
+	{"build", "build [flags] file.s - assemble and link using linker script", runBuild},
+	{"run", "run [flags] file.s - build and emulate program", runRun},
+	{"disasm", "disasm [flags] image - disassemble raw, Intel HEX or Logisim image", runDisasm},
+}
+
 func main() {
-	filename := os.Args[1]
-	fmt.Printf("Parsing %s...\n\n", filename)
-	file, _ := os.Open(filename)
-	stmt, err := p.NewParser(bufio.NewReader(file)).ParseFile()
+	os.Exit(dispatch(os.Args[1:]))
+}
+
+func dispatch(args []string) int {
+	if len(args) == 0 {
+		usage()
+		return exitUsage
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
+		}
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage()
+		return exitOK
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+	usage()
+	return exitUsage
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: preprocessor <command> [flags] file\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %s\n", cmd.usage)
+	}
+}
+
+//listFlag - flag which may be repeated
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+//options - flags shared by subcommands
+type options struct {
+	defines   listFlag
+	undefines listFlag
+	build     int
+	includes  listFlag
+	script    string
+	output    string
+	format    string
+	arch      string
+	cycles    int
+	inputs    listFlag
+	json      bool
+	maxDepth  int
+	maxIter   int
+}
+
+//newFlagSet returns flags of subcommand which preprocesses source file
+func newFlagSet(name string, opts *options) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Var(&opts.defines, "D", "define `NAME[=VALUE]` before processing")
+	fs.Var(&opts.undefines, "U", "undefine `NAME` after -D definitions")
+	fs.IntVar(&opts.build, "build", 0, "build counter, value of __BUILD__")
+	fs.Var(&opts.includes, "I", "add `dir` to import search path")
+	fs.StringVar(&opts.script, "T", "", "linker script `file`")
+	fs.StringVar(&opts.arch, "arch", "", "processor `name` overriding ARCHITECTURE of linker script")
+	fs.IntVar(&opts.maxDepth, "max-macro-depth", p.DefaultMacroDepth, "limit of nested macro calls")
+	fs.IntVar(&opts.maxIter, "max-iterations", p.DefaultMaxIterations, "limit of iterations of #rept, #for and #while")
+	return fs
+}
+
+func outputFlag(fs *flag.FlagSet, opts *options) {
+	fs.StringVar(&opts.output, "o", "", "output `file`")
+}
+
+//parseArgs parses flags and returns the only positional argument
+func parseArgs(fs *flag.FlagSet, args []string) (string, bool) {
+	if err := fs.Parse(args); err != nil {
+		return "", false
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "%s: exactly one input file expected\n", fs.Name())
+		fs.Usage()
+		return "", false
+	}
+	return fs.Arg(0), true
+}
+
+//fail prints diagnostics and returns failure exit code
+func fail(err error) int {
+	fmt.Fprintln(os.Stderr, err)
+	return exitFailure
+}
+
+func linkerScript(opts *options) (p.LinkerScript, error) {
+	script := p.DefaultLinkerScript()
+	if opts.script != "" {
+		var err error
+		if script, err = p.OpenLinkerScript(opts.script); err != nil {
+			return script, err
+		}
+	}
+	if opts.arch != "" {
+		script.ARCHITECTURE = opts.arch
+	}
+	return script, nil
+}
+
+//preprocess loads file with imports and evaluates directives
+func preprocess(filename string, script *p.LinkerScript, opts *options) (p.Program, error) {
+	module, err := p.NewLoader(script, opts.includes).LoadModule(filename)
 	if err != nil {
-		fmt.Printf(err.Error())
+		return p.Program{}, err
+	}
+	ppOpts := p.Options{
+		Defines:       make(map[string]string),
+		Undefines:     opts.undefines,
+		Arch:          script.ARCHITECTURE,
+		Build:         opts.build,
+		MaxMacroDepth: opts.maxDepth,
+		MaxIterations: opts.maxIter,
+	}
+	for _, def := range opts.defines {
+		name, value := def, ""
+		if i := strings.Index(def, "="); i >= 0 {
+			name, value = def[:i], def[i+1:]
+		}
+		ppOpts.Defines[name] = value
+	}
+	pp, err := p.NewPreprocessorWithOptions(ppOpts)
+	if err != nil {
+		return p.Program{}, err
+	}
+	prog, err := pp.ProcessModule(module)
+	for _, warn := range pp.Warnings() {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warn)
+	}
+	return prog, err
+}
+
+//output opens output file or returns stdout
+func output(filename string) (io.WriteCloser, error) {
+	if filename == "" || filename == "-" {
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.Create(filename)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func runParse(args []string) int {
+	var opts options
+	fs := flag.NewFlagSet("parse", flag.ContinueOnError)
+	fs.BoolVar(&opts.json, "json", false, "write AST as JSON")
+	filename, ok := parseArgs(fs, args)
+	if !ok {
+		return exitUsage
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return fail(err)
+	}
+	defer f.Close()
+	prog, err := p.NewFileParser(f, filename).ParseFile()
+	if opts.json {
+		if err := writeJSON(os.Stdout, prog); err != nil {
+			return fail(err)
+		}
 	} else {
-		p.PrintProg(stmt)
+		p.PrintProg(prog)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
+
+//writeJSON writes indented JSON of program
+func writeJSON(w io.Writer, prog p.Program) error {
+	data, err := json.MarshalIndent(prog, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+func runFmt(args []string) int {
+	fs := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	write := fs.Bool("w", false, "write result to source file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "fmt: input files expected\n")
+		fs.Usage()
+		return exitUsage
+	}
+	status := exitOK
+	for _, filename := range fs.Args() {
+		if err := formatFile(filename, *write); err != nil {
+			status = fail(err)
+		}
+	}
+	return status
+}
+
+//formatFile formats source file, file with syntax errors is left untouched
+func formatFile(filename string, write bool) error {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	prog, err := p.NewFileParser(bytes.NewReader(src), filename).ParseFile()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := p.Format(&buf, prog); err != nil {
+		return err
+	}
+	if !write {
+		_, err := os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	if bytes.Equal(src, buf.Bytes()) {
+		return nil
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, buf.Bytes(), info.Mode())
+}
+
+func runPreprocess(args []string) int {
+	var opts options
+	fs := newFlagSet("preprocess", &opts)
+	outputFlag(fs, &opts)
+	fs.BoolVar(&opts.json, "json", false, "write preprocessed AST as JSON")
+	filename, ok := parseArgs(fs, args)
+	if !ok {
+		return exitUsage
+	}
+	script, err := linkerScript(&opts)
+	if err != nil {
+		return fail(err)
+	}
+	prog, err := preprocess(filename, &script, &opts)
+	if err != nil {
+		return fail(err)
+	}
+	w, err := output(opts.output)
+	if err != nil {
+		return fail(err)
+	}
+	write := p.WriteSource
+	if opts.json {
+		write = writeJSON
+	}
+	if err := write(w, prog); err != nil {
+		w.Close()
+		return fail(err)
+	}
+	if err := w.Close(); err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
+
+//build preprocesses and links source file
+func build(filename string, opts *options) (p.Image, p.LinkerScript, error) {
+	script, err := linkerScript(opts)
+	if err != nil {
+		return p.Image{}, script, err
+	}
+	prog, err := preprocess(filename, &script, opts)
+	if err != nil {
+		return p.Image{}, script, err
+	}
+	//#pragma arch replaces default architecture, but not the chosen one
+	if arch := prog.Arch(); arch != "" {
+		chosen := opts.arch != "" || (opts.script != "" && script.ARCHITECTURE != "")
+		if chosen && !strings.EqualFold(script.ARCHITECTURE, arch) {
+			return p.Image{}, script, fmt.Errorf("program requires architecture %s, target is %s", arch, script.ARCHITECTURE)
+		}
+		script.ARCHITECTURE = arch
+	}
+	img, err := script.Link(prog)
+	return img, script, err
+}
+
+func runBuild(args []string) int {
+	var opts options
+	fs := newFlagSet("build", &opts)
+	outputFlag(fs, &opts)
+	fs.StringVar(&opts.format, "f", "", "output `format`: bin, ihex, srec, logisim or memh")
+	filename, ok := parseArgs(fs, args)
+	if !ok {
+		return exitUsage
+	}
+	img, script, err := build(filename, &opts)
+	if err != nil {
+		return fail(err)
+	}
+	out := script.OUTPUT
+	if opts.output != "" {
+		out = opts.output
+	}
+	format := p.FormatBinary
+	switch {
+	case opts.format != "":
+		format, err = p.ParseOutputFormat(opts.format)
+	case out != "-":
+		format, err = p.FormatFromExt(out)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	w, err := output(out)
+	if err != nil {
+		return fail(err)
+	}
+	if err := p.WriteImage(w, img, format); err != nil {
+		w.Close()
+		return fail(err)
+	}
+	if err := w.Close(); err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
+
+func runRun(args []string) int {
+	var opts options
+	fs := newFlagSet("run", &opts)
+	fs.IntVar(&opts.cycles, "cycles", 1000, "maximum number of executed instructions")
+	fs.Var(&opts.inputs, "in", "set input `port=value` before running")
+	filename, ok := parseArgs(fs, args)
+	if !ok {
+		return exitUsage
+	}
+	img, script, err := build(filename, &opts)
+	if err != nil {
+		return fail(err)
+	}
+	arch, err := p.LookupProfile(script.ARCHITECTURE)
+	if err != nil {
+		return fail(err)
+	}
+	start, mem := img.Memory()
+	emu := p.NewEmulator(arch, append(make([]byte, start), mem...))
+	for _, in := range opts.inputs {
+		var port, value int
+		if _, err := fmt.Sscanf(in, "%d=%d", &port, &value); err != nil {
+			fmt.Fprintf(os.Stderr, "run: invalid input %q, port=value expected\n", in)
+			return exitUsage
+		}
+		if err := emu.SetInput(port, value); err != nil {
+			return fail(err)
+		}
+	}
+	cycles, err := emu.Run(opts.cycles)
+	fmt.Printf("cycles: %d halted: %t\n", cycles, emu.Halted())
+	fmt.Printf("a: %d b: %d pc: %d carry: %t\n", emu.A(), emu.B(), emu.PC(), emu.Carry())
+	fmt.Printf("out:")
+	for port := 0; port < 16; port++ {
+		value, _ := emu.Output(port)
+		fmt.Printf(" %d", value)
+	}
+	fmt.Println()
+	if err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
+
+func runDisasm(args []string) int {
+	var opts options
+	fs := flag.NewFlagSet("disasm", flag.ContinueOnError)
+	fs.StringVar(&opts.arch, "arch", "", "processor `name`, opcodes it does not support are decoded as data")
+	outputFlag(fs, &opts)
+	fs.StringVar(&opts.format, "f", "", "image `format`: bin, ihex or logisim, detected by extension by default")
+	filename, ok := parseArgs(fs, args)
+	if !ok {
+		return exitUsage
+	}
+	format, err := p.FormatFromExt(filename)
+	if opts.format != "" {
+		format, err = p.ParseOutputFormat(opts.format)
+	} else if err != nil {
+		//unknown extensions are read as raw binary
+		format, err = p.FormatBinary, nil
+	}
+	if err != nil {
+		return fail(err)
+	}
+	var arch *p.Profile
+	if opts.arch != "" {
+		if arch, err = p.LookupProfile(opts.arch); err != nil {
+			return fail(err)
+		}
+	}
+	origin, code, err := p.ReadImageFile(filename, format)
+	if err != nil {
+		return fail(err)
+	}
+	w, err := output(opts.output)
+	if err != nil {
+		return fail(err)
+	}
+	if err := p.WriteSource(w, p.Disassemble(origin, code, arch)); err != nil {
+		w.Close()
+		return fail(err)
 	}
-	ld := os.Args[2]
-	f, _ := p.OpenLinkerScript(ld)
-	pt, _ := f.GetPartitionList()
-	for _, val := range pt {
-		println(val)
+	if err := w.Close(); err != nil {
+		return fail(err)
 	}
+	return exitOK
 }